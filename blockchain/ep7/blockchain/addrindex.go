@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	addrPrefix    = []byte("addr-")     //prefix of the transactions of an address: addr-PUBKEYHASH HEIGHT TXID
+	addrIndexFlag = []byte("addrindex") //saved when the address index is enabled, so every process keeps it updated
+)
+
+// AddressTx is a transaction of the history of an address.
+type AddressTx struct {
+	TxID     []byte
+	Height   int //height of the block of the transaction
+	Received int //value of the outputs of the transaction locked to the address
+	Sent     int //value of the outputs of the address spent by the transaction
+}
+
+// Direction return "in" if the address received coins with the transaction
+// and "out" if it paid, the change back to the address is not counted.
+func (a AddressTx) Direction() string {
+	if a.Sent > a.Received {
+		return "out"
+	}
+
+	return "in"
+}
+
+// Amount return the value that the address received or paid.
+func (a AddressTx) Amount() int {
+	if a.Sent > a.Received {
+		return a.Sent - a.Received
+	}
+
+	return a.Received - a.Sent
+}
+
+// addrKey return the key of the transaction txID inside the history of pubKeyHash.
+func addrKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	key := make([]byte, len(addrPrefix)+len(pubKeyHash)+8, len(addrPrefix)+len(pubKeyHash)+8+len(txID))
+	copy(key, addrPrefix)
+	copy(key[len(addrPrefix):], pubKeyHash)
+	binary.BigEndian.PutUint64(key[len(addrPrefix)+len(pubKeyHash):], uint64(height))
+
+	return append(key, txID...)
+}
+
+// addressTxs return the transactions of the block for every address (the key
+// of the map is the pubKeyHash, or the script hash of a P2SH address), the
+// outputs that are not P2PKH or P2SH have no address. prevOutput return the output spent by an input.
+func addressTxs(block *Block, prevOutput func(in TxInput) (TxOutput, error)) (map[string][]*AddressTx, error) {
+	history := make(map[string][]*AddressTx)
+
+	for _, tx := range block.Transactions {
+		byAddress := make(map[string]*AddressTx)
+		entry := func(pubKeyHash []byte) *AddressTx {
+			if pubKeyHash == nil { //the outputs without an address are not indexed
+				return &AddressTx{}
+			}
+			a, ok := byAddress[string(pubKeyHash)]
+			if !ok {
+				a = &AddressTx{TxID: tx.ID, Height: block.Height}
+				byAddress[string(pubKeyHash)] = a
+				history[string(pubKeyHash)] = append(history[string(pubKeyHash)], a)
+			}
+			return a
+		}
+
+		if !tx.IsCoinbase() {
+			for _, in := range tx.Inputs {
+				out, err := prevOutput(in)
+				if err != nil {
+					return nil, err
+				}
+				entry(out.AddressHash()).Sent += out.Value
+			}
+		}
+		for _, out := range tx.Outputs {
+			entry(out.AddressHash()).Received += out.Value
+		}
+	}
+
+	return history, nil
+}
+
+// spentOutputs return a prevOutput function for addressTxs that reads the
+// outputs spent by a block from its undo data.
+func spentOutputs(undo BlockUndo) func(in TxInput) (TxOutput, error) {
+	spent := make(map[string]TxOutput)
+	for _, s := range undo.Spent {
+		spent[outpoint(s.TxID, s.Index)] = s.Output
+	}
+
+	return func(in TxInput) (TxOutput, error) {
+		out, ok := spent[outpoint(in.ID, in.Out)]
+		if !ok {
+			return TxOutput{}, fmt.Errorf("Spent output %x:%d is not inside the undo data", in.ID, in.Out)
+		}
+		return out, nil
+	}
+}
+
+// putAddressTxs add the transactions of history (returned by addressTxs) to the address index.
+func putAddressTxs(w Writer, history map[string][]*AddressTx) error {
+	for pubKeyHash, txs := range history {
+		for _, a := range txs {
+			value := make([]byte, 16)
+			binary.BigEndian.PutUint64(value[:8], uint64(a.Received))
+			binary.BigEndian.PutUint64(value[8:], uint64(a.Sent))
+			if err := w.Put(addrKey([]byte(pubKeyHash), a.Height, a.TxID), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteAddressTxs remove the transactions of history from the address index.
+func deleteAddressTxs(w Writer, history map[string][]*AddressTx) error {
+	for pubKeyHash, txs := range history {
+		for _, a := range txs {
+			if err := w.Delete(addrKey([]byte(pubKeyHash), a.Height, a.TxID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// HasAddressIndex return true if the address index is enabled.
+func (chain *BlockChain) HasAddressIndex() (bool, error) {
+	return hasAddressIndex(chain.Database.Get)
+}
+
+// hasAddressIndex check the flag of the address index with get, that can be
+// the Get of a Store or of a Batch.
+func hasAddressIndex(get func(key []byte) ([]byte, error)) (bool, error) {
+	_, err := get(addrIndexFlag)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// ReindexAddresses enable the address index and build it from the blocks of
+// the main chain. From now on the index is updated with every block.
+func (chain *BlockChain) ReindexAddresses() error {
+	if err := deleteByPrefix(chain.Database, addrPrefix); err != nil {
+		return err
+	}
+
+	//the outputs spent by a block are found with the transaction index
+	prevOutput := func(in TxInput) (TxOutput, error) {
+		prevTX, err := chain.FindTransaction(in.ID)
+		if err != nil {
+			return TxOutput{}, err
+		}
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return TxOutput{}, fmt.Errorf("Output %x:%d doesn't exist", in.ID, in.Out)
+		}
+		return prevTX.Outputs[in.Out], nil
+	}
+
+	//the store can't be read inside BulkWrite, so first we read the blocks
+	var blocks []*Block
+	iter := chain.Iterator()
+	for len(iter.CurrentHash) > 0 {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+	}
+
+	history := make(map[string][]*AddressTx)
+	for _, block := range blocks {
+		txs, err := addressTxs(block, prevOutput)
+		if err != nil {
+			return err
+		}
+		for pubKeyHash, a := range txs {
+			history[pubKeyHash] = append(history[pubKeyHash], a...)
+		}
+	}
+
+	err := chain.Database.BulkWrite(func(w Writer) error {
+		return putAddressTxs(w, history)
+	})
+	if err != nil {
+		return err
+	}
+
+	return chain.Database.Put(addrIndexFlag, []byte{1})
+}
+
+// AddressHistory return the transactions of the main chain that pay or spend
+// the outputs of pubKeyHash, from the oldest to the newest. It fails with
+// ErrNoAddressIndex if the address index is not enabled.
+func (chain *BlockChain) AddressHistory(pubKeyHash []byte) ([]AddressTx, error) {
+	enabled, err := chain.HasAddressIndex()
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrNoAddressIndex
+	}
+
+	var history []AddressTx
+	prefix := append(append([]byte{}, addrPrefix...), pubKeyHash...)
+	err = chain.Database.Iterate(prefix, func(key, value []byte) error {
+		key = key[len(prefix):]
+		if len(key) < 8 || len(value) != 16 {
+			return fmt.Errorf("Address index entry %x is not valid", key)
+		}
+
+		history = append(history, AddressTx{
+			TxID:     key[8:],
+			Height:   int(binary.BigEndian.Uint64(key[:8])),
+			Received: int(binary.BigEndian.Uint64(value[:8])),
+			Sent:     int(binary.BigEndian.Uint64(value[8:])),
+		})
+		return nil
+	})
+
+	return history, err
+}
@@ -2,80 +2,235 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
-	"encoding/gob"
-	"log"
+	"fmt"
+	"time"
 )
 
+// The Version of the headers decides how they are hashed by the proof of work.
+// The headers with LegacyBlockVersion were mined before the headers had a
+// version, they are hashed like the old versions did. From SigRootBlockVersion
+// the header has also the SigRoot, so the hash of the block covers the
+// ScriptSigs that are not part of the IDs of the transactions.
+const (
+	LegacyBlockVersion  = 0
+	BinaryBlockVersion  = 1
+	SigRootBlockVersion = 2
+	BlockVersion        = SigRootBlockVersion //the Version of the new headers
+)
+
+//BlockHeader contain the fields of the block that are hashed by the proof of work,
+//so a header can be saved and checked without the transactions.
+type BlockHeader struct {
+	Version    int    // version of the header
+	PrevHash   []byte // rappresent the last block hash, allows to link block together
+	MerkleRoot []byte // root of the merkle tree of the transactions
+	SigRoot    []byte // root of the merkle tree of the whole transactions with their ScriptSigs
+	Timestamp  int64  // when the block has been created (unix time in seconds)
+	Bits       uint32 // compact rappresentation of the target of the proof of work
+	Nonce      int    // is used to derived the hash(which met the target )
+}
+
 //Block contain the basic data for Blockchain.
 type Block struct {
+	BlockHeader
 	Hash         []byte         // the hash who rappresent the block itself
+	Height       int            // position of the block in the chain, the genesis block has height 0
+	Seal         []byte         // data of the Consensus that is not hashed, e.g. the signature of the authority
 	Transactions []*Transaction // transactions inside the block
-	PrevHash     []byte         // rappresent the last block hash, allows to link block together
-	Nonce        int            // is used to derived the hash(which met the target )
 }
 
 // Genesis create the first Inizial block in the blockChian.
-func Genesis(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+func Genesis(coinbase *Transaction) (*Block, error) {
+	return CreateBlock([]*Transaction{coinbase}, []byte{}, 0, time.Now().Unix(), BigToCompact(powLimit))
+}
+
+// CreateBlock Create the current block, it fails with ErrNonceExhausted only
+// if the coinbase doesn't have an extra nonce.
+func CreateBlock(txs []*Transaction, prevHash []byte, height int, timestamp int64, bits uint32) (*Block, error) {
+	block := newBlock(txs, prevHash, height, timestamp, bits)
+
+	//without a context the mining never stops
+	if err := MineBlock(context.Background(), block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
 }
 
-// CreateBlock Create the current block.
-func CreateBlock(txs []*Transaction, prevHash []byte) *Block {
+// newBlock return the block that must be mined, without Nonce and Hash.
+func newBlock(txs []*Transaction, prevHash []byte, height int, timestamp int64, bits uint32) *Block {
 	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:   BlockVersion,
+			PrevHash:  prevHash,
+			Timestamp: timestamp,
+			Bits:      bits,
+		},
 		Hash:         []byte{},
+		Height:       height,
 		Transactions: txs,
-		PrevHash:     prevHash,
-		Nonce:        0,
 	}
-	pow := NewProof(block)
-	nonce, hash := pow.Run()
-	block.Nonce = nonce
-	block.Hash = hash
+	block.MerkleRoot = block.HashTransactions()
+	block.SigRoot = block.HashSignatures()
+
 	return block
+}
+
+// MineBlock find the Nonce and the Hash of the block with the proof of work
+// on all the cores. When all the nonces have been tried it changes the extra
+// nonce of the coinbase (the first transaction), that changes the MerkleRoot,
+// the SigRoot and so the hashes. It stops with the error of ctx when ctx is
+// done, e.g. when another node has found a block with the same height.
+func MineBlock(ctx context.Context, block *Block) error {
+	start := time.Now()
+	var hashes uint64
+
+	for extraNonce := uint64(1); ; extraNonce++ {
+		pow := NewProof(&block.BlockHeader)
+		nonce, hash, err := pow.Run(ctx)
+		hashes += pow.Hashes
+		if err == ErrNonceExhausted {
+			if err := block.Transactions[0].SetExtraNonce(extraNonce); err != nil {
+				return err
+			}
+			block.MerkleRoot = block.HashTransactions()
+			block.SigRoot = block.HashSignatures()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		block.Nonce = nonce
+		block.Hash = hash
+		break
+	}
 
+	elapsed := time.Since(start)
+	fmt.Printf("Block mined in %s: %d hashes, %.0f hashes/s\n", elapsed.Round(time.Millisecond), hashes, float64(hashes)/elapsed.Seconds())
+
+	return nil
 }
 
-// Serialize encode the data from a block to a []bytes
+// Serialize encode the data from a block to a []bytes with the binary format of encoding.go
 // this method help us to work with the DB because our DB works only with an arrays of bytes
 func (b *Block) Serialize() []byte {
+	e := newEncoder()
+	e.block(b)
+
+	return e.buf.Bytes()
+}
+
+// Deserialize decode the data from []bytes to a Block, it fails with
+// ErrInvalidEncoding if data is not a block of the binary format.
+func Deserialize(data []byte) (*Block, error) {
+	d := newDecoder(data)
+	block := d.block()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// BlockHash return the hash of the header, that is the Hash of the block.
+func (h *BlockHeader) BlockHash() []byte {
+	hash := sha256.Sum256(h.hashData(h.Nonce))
+	return hash[:]
+}
 
-	var res bytes.Buffer
+// hashData return the data of the header that is hashed with nonce.
+func (h *BlockHeader) hashData(nonce int) []byte {
+	header := *h
+	header.Nonce = nonce
 
-	encoder := gob.NewEncoder(&res) //make a new encoder
+	if header.Version != LegacyBlockVersion {
+		return header.Serialize()
+	}
+
+	//the first version didn't have the timestamp, the bits and the merkle
+	//tree, the difficulty was always InitialDifficulty
+	if header.isBaseline() {
+		return bytes.Join(
+			[][]byte{
+				header.PrevHash,
+				header.MerkleRoot,
+				ToHex(int64(nonce)),
+				ToHex(InitialDifficulty),
+			},
+			[]byte{},
+		)
+	}
 
-	err := encoder.Encode(b) // encode our block
-	HandleErr(err)
-	//return bytes rappresentation of our block
-	return res.Bytes()
+	//the legacy headers don't have the version inside the hash
+	return bytes.Join(
+		[][]byte{
+			header.PrevHash,
+			header.MerkleRoot,
+			ToHex(header.Timestamp),
+			ToHex(int64(nonce)),
+			ToHex(int64(header.Bits)),
+		},
+		[]byte{},
+	)
 }
 
-// Deserialize decode the data from []bytes to a Block
-func Deserialize(data []byte) *Block {
-	var block Block
+// isBaseline return true if the header was mined by the first version, that
+// didn't save the time of the blocks: the migration gives them the Timestamp 0.
+func (h *BlockHeader) isBaseline() bool {
+	return h.Version == LegacyBlockVersion && h.Timestamp == 0
+}
 
-	reader := bytes.NewReader(data)   //make new reader
-	decoder := gob.NewDecoder(reader) //make new decoder
-	err := decoder.Decode(&block)
-	HandleErr(err)
-	return &block
+// Serialize encode the header with the binary format of encoding.go.
+func (h *BlockHeader) Serialize() []byte {
+	e := newEncoder()
+	e.header(h)
 
+	return e.buf.Bytes()
 }
 
-// HandleErr is halper to handle the errors
-func HandleErr(err error) {
-	if err != nil {
-		log.Panic(err)
+// DeserializeHeader decode the data from []bytes to a BlockHeader.
+func DeserializeHeader(data []byte) (*BlockHeader, error) {
+	d := newDecoder(data)
+	header := d.header()
+	if err := d.finish(); err != nil {
+		return nil, err
 	}
+
+	return &header, nil
 }
 
+// HashTransactions return the root of the merkle tree of the transactions IDs,
+// the blocks of the first version have the hash of all the IDs joined together.
 func (b *Block) HashTransactions() []byte {
 	var txHashes [][]byte
-	var txHash [32]byte
 	for _, tx := range b.Transactions {
 		txHashes = append(txHashes, tx.ID)
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
+	if b.isBaseline() {
+		txHash := sha256.Sum256(bytes.Join(txHashes, []byte{}))
+		return txHash[:]
+	}
+	tree := NewMerkleTree(txHashes)
+
+	return tree.RootNode.Data
+}
+
+// HashSignatures return the root of the merkle tree of the FullHash of the
+// transactions, the SigRoot of the headers with SigRootBlockVersion. The
+// older headers don't have it.
+func (b *Block) HashSignatures() []byte {
+	if b.Version < SigRootBlockVersion {
+		return nil
+	}
+
+	var txHashes [][]byte
+	for _, tx := range b.Transactions {
+		txHashes = append(txHashes, tx.FullHash())
+	}
+	tree := NewMerkleTree(txHashes)
 
-	return txHash[:]
+	return tree.RootNode.Data
 }
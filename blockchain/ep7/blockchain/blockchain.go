@@ -2,109 +2,499 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
+	"context"
+	"encoding/binary"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"os"
-	"runtime"
+	"sort"
+	"time"
 
-	"github.com/dgraph-io/badger"
+	"github.com/RachidP/BlockChain/wallet"
 )
 
 const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST" //verify if the blockchain exist.
 	genesisData = "First transaction from Genesis"
+
+	medianTimeBlocks   = 11          //number of blocks used to calculate the median time past
+	maxFutureBlockTime = 2 * 60 * 60 //a block can't be more than 2 hours in the future (in seconds)
+)
+
+var (
+	headerPrefix = []byte("hdr-")  //prefix of the header and the height of a block
+	bodyPrefix   = []byte("body-") //prefix of the transactions of a block
 )
 
 // BlockChain rappresent a BlockChain
 type BlockChain struct {
-	LastHash []byte //last Hash of the last block in the chain
-	Database *badger.DB
+	LastHash  []byte //last Hash of the last block in the chain
+	Database  Store
+	Consensus Consensus //seal and verify the blocks
+	Mempool   *Mempool  //pool of the node, if it is not nil Reorganize updates it
 }
 
 //BlockChainIterator iterate over a blockchain
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    Store
 }
 
-// InitBlockChain build our initial BlockChian.
-func InitBlockChain(address string) *BlockChain {
-	var lastHash []byte
-	if DBExist() {
-		fmt.Println("Blockchain already exists")
-		runtime.Goexit()
+// InitBlockChain build our initial BlockChian inside the directory of opts,
+// it fails with ErrChainExists if there is already a BlockChain.
+func InitBlockChain(address string, opts Options) (*BlockChain, error) {
+	if opts.Store == nil && DBExist(opts.DBPath()) {
+		return nil, ErrChainExists
 	}
 
-	//set path where to save data
-	opts := badger.DefaultOptions
-	opts.Dir = dbPath      // where the db store the keys and metadata
-	opts.ValueDir = dbPath //where the db will store all the values
+	//make the genesis block before opening the db, so a wrong address doesn't leave an empty db
+	cbtx, err := CoinbaseTx(address, genesisData, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	consensus := opts.Consensus
+	if consensus == nil {
+		consensus = PoW{}
+	}
+	genesis := newBlock([]*Transaction{cbtx}, []byte{}, 0, time.Now().Unix(), 0) //make genesis block
+	if err := consensus.Prepare(nil, genesis); err != nil {
+		return nil, err
+	}
+	if err := consensus.Seal(context.Background(), genesis); err != nil {
+		return nil, err
+	}
+	fmt.Println("Genesis Created")
 
 	//open the db
-	db, err := badger.Open(opts)
-	HandleErr(err)
+	db, err := openStore(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	//db.Update access to db for reading a writing transaction
+	//the batch save all the keys of the genesis together
+	err = db.Batch(func(batch Batch) error {
+		//a store given by the user can contain already a chain
+		if _, err := batch.Get([]byte("lh")); err == nil {
+			return ErrChainExists
+		}
 
-	err = db.Update(func(txn *badger.Txn) error {
+		//uses the genesis hash as the key of the genesis header and body
+		if err := putBlock(batch, genesis); err != nil {
+			return err
+		}
+		if err := batch.Put(append(workPrefix, genesis.Hash...), consensus.Work(genesis).Bytes()); err != nil {
+			return err
+		}
+		if err := saveConsensus(batch, consensus); err != nil {
+			return err
+		}
+		if err := indexBlock(batch, genesis); err != nil {
+			return err
+		}
+		if err := batch.Put(encodingKey, []byte{DBVersion}); err != nil {
+			return err
+		}
 		//lh := is the key (last hash)
+		return batch.Put([]byte("lh"), genesis.Hash)
+	})
+	if err != nil {
+		if opts.Store == nil {
+			db.Close()
+		}
+		return nil, err
+	}
 
-		//no existing blockchain in our db
-		fmt.Println("No existing blockchain found in the db")
-		cbtx := CoinbaseTx(address, genesisData)
-		genesis := Genesis(cbtx) //make genesis block
-		fmt.Println("Genesis Created")
+	blockchain := BlockChain{LastHash: genesis.Hash, Database: db, Consensus: consensus}
+	return &blockchain, nil
+}
 
-		//uses the genesis hash as the key of the genesis hash, serialize the block and put into db
-		err := txn.Set(genesis.Hash, genesis.Serialize())
-		HandleErr(err)
-		err = txn.Set([]byte("lh"), genesis.Hash)
+// AddBlock mine a Block with the transactions and add it to the BlockChain,
+// the coinbase of the block pay the reward to minerAddress. The block is
+// validated before it is saved, the block, the last hash and the UTXOSet are
+// saved in the same batch.
+func (chain *BlockChain) AddBlock(minerAddress string, transactions []*Transaction) (*Block, error) {
+	return chain.AddBlockContext(context.Background(), minerAddress, transactions)
+}
 
-		lastHash = genesis.Hash
+// AddBlockContext is like AddBlock but the mining stops with the error of ctx
+// when ctx is done.
+func (chain *BlockChain) AddBlockContext(ctx context.Context, minerAddress string, transactions []*Transaction) (*Block, error) {
+	newBlock, err := chain.NewBlockTemplate(minerAddress, transactions)
+	if err != nil {
+		return nil, err
+	}
+	if err := chain.Consensus.Seal(ctx, newBlock); err != nil {
+		return nil, err
+	}
 
-		return err
+	if err := chain.ValidateBlock(newBlock); err != nil {
+		return nil, err
+	}
+
+	work, err := chain.ChainWork(newBlock.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+	work.Add(work, chain.Consensus.Work(newBlock))
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	err = chain.Database.Batch(func(batch Batch) error {
+
+		if err := putBlock(batch, newBlock); err != nil {
+			return err
+		}
+
+		if err := batch.Put(append(workPrefix, newBlock.Hash...), work.Bytes()); err != nil {
+			return err
+		}
+
+		if err := UTXOSet.update(batch, newBlock); err != nil {
+			return err
+		}
+
+		return batch.Put([]byte("lh"), newBlock.Hash)
 
 	})
+	if err != nil {
+		return nil, err
+	}
+	chain.LastHash = newBlock.Hash
+
+	return newBlock, nil
 
-	HandleErr(err)
-	blockchain := BlockChain{LastHash: lastHash, Database: db}
-	return &blockchain
 }
 
-// AddBlock add a Block to the BlockChain
-func (chain *BlockChain) AddBlock(transactions []*Transaction) *Block {
+// NewBlockTemplate return the block after the last block with a coinbase that
+// pay the reward and the fees to minerAddress and the transactions, the block
+// must be sealed with the Seal of the Consensus.
+func (chain *BlockChain) NewBlockTemplate(minerAddress string, transactions []*Transaction) (*Block, error) {
+	lastBlock, err := chain.lastBlock()
+	if err != nil {
+		return nil, err
+	}
+	lastHash, lastHeight := lastBlock.Hash, lastBlock.Height
 
-	var lastHash []byte
+	//the time of the block must be greater than the median time of the previous blocks
+	medianTime, err := chain.MedianTimePast(lastHash)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	if timestamp <= medianTime {
+		timestamp = medianTime + 1
+	}
 
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		HandleErr(err)
-		lastHash, err = item.Value()
-		return err
-	})
-	HandleErr(err)
+	//the coinbase is always the first transaction of the block
+	UTXOSet := UTXOSet{Blockchain: chain}
+	fees := 0
+	for _, tx := range transactions {
+		fee, err := UTXOSet.TransactionFee(tx)
+		if err != nil {
+			return nil, err
+		}
+		fees += fee
+	}
+	cbTx, err := CoinbaseTx(minerAddress, "", lastHeight+1, fees)
+	if err != nil {
+		return nil, err
+	}
+	transactions = append([]*Transaction{cbTx}, transactions...)
 
-	newBlock := CreateBlock(transactions, lastHash)
+	block := newBlock(transactions, lastHash, lastHeight+1, timestamp, 0)
+	if err := chain.Consensus.Prepare(chain, block); err != nil {
+		return nil, err
+	}
 
-	err = chain.Database.Update(func(txn *badger.Txn) error {
+	return block, nil
+}
 
-		err := txn.Set(newBlock.Hash, newBlock.Serialize())
-		HandleErr(err)
+// ImportBlock validate and store a block received from another node.
+// The block can be on a side branch, the node always follow the branch with
+// the most work and reorganize the chain when another branch has more work.
+// The blocks removed by a reorganization because they are not valid are rejected.
+func (chain *BlockChain) ImportBlock(block *Block) error {
+	//the block is already inside the db
+	if _, err := chain.GetBlock(block.Hash); err == nil {
+		return nil
+	}
+	invalid, err := chain.isInvalid(block.Hash)
+	if err != nil {
+		return err
+	}
+	if invalid {
+		return invalidBlock("Block %x has already been rejected", block.Hash)
+	}
 
-		err = txn.Set([]byte("lh"), newBlock.Hash)
+	if err := chain.ValidateBlock(block); err != nil {
+		return err
+	}
 
-		chain.LastHash = newBlock.Hash
+	work, err := chain.ChainWork(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	work.Add(work, chain.Consensus.Work(block))
 
+	lastWork, err := chain.ChainWork(chain.LastHash)
+	if err != nil {
 		return err
+	}
+	//the block extends the last block, it is saved with the UTXOSet
+	extend := work.Cmp(lastWork) > 0 && bytes.Equal(block.PrevHash, chain.LastHash)
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	err = chain.Database.Batch(func(batch Batch) error {
+		if err := putBlock(batch, block); err != nil {
+			return err
+		}
+		if err := batch.Put(append(workPrefix, block.Hash...), work.Bytes()); err != nil {
+			return err
+		}
+		if !extend {
+			return nil
+		}
 
+		if err := UTXOSet.update(batch, block); err != nil {
+			return err
+		}
+		return batch.Put([]byte("lh"), block.Hash)
 	})
+	if err != nil {
+		return err
+	}
+	if extend {
+		chain.LastHash = block.Hash
+		return nil
+	}
+
+	//the block is on a branch with less work, we keep it only on the side
+	if work.Cmp(lastWork) <= 0 {
+		return nil
+	}
 
-	HandleErr(err)
-	return newBlock
+	return chain.Reorganize(block)
+}
+
+// MedianTimePast return the median of the timestamps of the last 11 blocks,
+// starting from the block with hash prevHash. It returns 0 if prevHash is empty.
+func (chain *BlockChain) MedianTimePast(prevHash []byte) (int64, error) {
+	var timestamps []int64
+
+	iter := BlockChainIterator{CurrentHash: prevHash, Database: chain.Database}
+	for len(iter.CurrentHash) > 0 && len(timestamps) < medianTimeBlocks {
+		block, err := iter.NextHeader()
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, block.Timestamp)
+	}
+
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
 
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// RequiredBits return the Bits that the block after the block with hash prevHash must have.
+func (chain *BlockChain) RequiredBits(prevHash []byte) (uint32, error) {
+	if len(prevHash) == 0 {
+		return BigToCompact(powLimit), nil
+	}
+
+	prev, err := chain.GetHeader(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	//the difficulty change only every RetargetInterval blocks, the first
+	//version didn't change it
+	if (prev.Height+1)%RetargetInterval != 0 || prev.isBaseline() {
+		return prev.Bits, nil
+	}
+
+	//go back to the first block of the interval
+	iter := BlockChainIterator{CurrentHash: prevHash, Database: chain.Database}
+	first := &prev
+	for first.Height > prev.Height+1-RetargetInterval {
+		if first, err = iter.NextHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	return NextBits(&prev, first), nil
+}
+
+// TransactionFee return the fee of the transaction, the value of the inputs
+// that is not spent by the outputs. It fails if an input spends an output that
+// doesn't exist, if an output is negative or if the sums overflow.
+func (chain *BlockChain) TransactionFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	inputs := 0
+	for _, in := range tx.Inputs {
+		prevTX, err := chain.FindTransaction(in.ID)
+		if err != nil {
+			return 0, err
+		}
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return 0, fmt.Errorf("Output %x:%d doesn't exist", in.ID, in.Out)
+		}
+		if inputs, err = addValue(inputs, prevTX.Outputs[in.Out].Value); err != nil {
+			return 0, fmt.Errorf("Input %x:%d: %w", in.ID, in.Out, err)
+		}
+	}
+	outputs, err := tx.OutputValue()
+	if err != nil {
+		return 0, err
+	}
+
+	return inputs - outputs, nil
+}
+
+// lastBlock return the last block saved inside the db.
+func (chain *BlockChain) lastBlock() (*Block, error) {
+	lastHash, err := chain.Database.Get([]byte("lh"))
+	if err != nil {
+		return nil, err
+	}
+
+	lastBlock, err := chain.GetBlock(lastHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lastBlock, nil
+}
+
+// GetBestHeight return the height of the last block.
+func (chain *BlockChain) GetBestHeight() (int, error) {
+	lastHash, err := chain.Database.Get([]byte("lh"))
+	if err != nil {
+		return 0, err
+	}
+
+	lastBlock, err := chain.GetHeader(lastHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return lastBlock.Height, nil
+}
+
+// GetBlockHashes return the hashes of all the blocks, from the last block to the genesis.
+func (chain *BlockChain) GetBlockHashes() ([][]byte, error) {
+	var blocks [][]byte
+
+	iter := chain.Iterator()
+
+	for {
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, block.Hash)
+
+		if len(block.PrevHash) == 0 {
+			break
+		}
+	}
+
+	return blocks, nil
+}
+
+// GetBlock get a block by his hash.
+func (chain *BlockChain) GetBlock(blockHash []byte) (Block, error) {
+	block, err := getBlock(chain.Database, blockHash)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return *block, nil
+}
+
+// getBlock read the block with hash blockHash from the store.
+func getBlock(store Store, blockHash []byte) (*Block, error) {
+	block, err := getHeader(store, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := store.Get(append(append([]byte{}, bodyPrefix...), blockHash...))
+	if err == ErrKeyNotFound {
+		return nil, fmt.Errorf("%w: transactions of %x", ErrBlockNotFound, blockHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDecoder(body)
+	block.Transactions = d.transactions()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// GetHeader get the header of a block by his hash, the block returned has
+// only the header, the hash and the height: the transactions are not read.
+func (chain *BlockChain) GetHeader(blockHash []byte) (Block, error) {
+	block, err := getHeader(chain.Database, blockHash)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return *block, nil
+}
+
+// getHeader read the header of the block with hash blockHash from the store.
+func getHeader(store Store, blockHash []byte) (*Block, error) {
+	data, err := store.Get(append(append([]byte{}, headerPrefix...), blockHash...))
+	if err == ErrKeyNotFound {
+		return nil, fmt.Errorf("%w: %x", ErrBlockNotFound, blockHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return deserializeHeader(blockHash, data)
+}
+
+// deserializeHeader decode the value saved by putBlock with the header of the block blockHash.
+func deserializeHeader(blockHash, data []byte) (*Block, error) {
+	d := newDecoder(data)
+	block := &Block{BlockHeader: d.header(), Hash: append([]byte{}, blockHash...), Height: d.int(), Seal: d.bytes()}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// headerValue return the value saved with the header of the block.
+func headerValue(block *Block) []byte {
+	e := newEncoder()
+	e.header(&block.BlockHeader)
+	e.varint(int64(block.Height))
+	e.bytes(block.Seal)
+
+	return e.buf.Bytes()
+}
+
+// putBlock save the header and the body of the block with w.
+func putBlock(w Writer, block *Block) error {
+	if err := w.Put(append(append([]byte{}, headerPrefix...), block.Hash...), headerValue(block)); err != nil {
+		return err
+	}
+
+	e := newEncoder()
+	e.transactions(block.Transactions)
+	return w.Put(append(append([]byte{}, bodyPrefix...), block.Hash...), e.buf.Bytes())
 }
 
 //Iterator convert a BlockChian struct into a BlochainIterator struct
@@ -117,63 +507,103 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 
 }
 
-func (iter *BlockChainIterator) Next() *Block {
-	var block *Block
+// Next return the current block and move the iterator to the previous block.
+func (iter *BlockChainIterator) Next() (*Block, error) {
+	block, err := getBlock(iter.Database, iter.CurrentHash)
+	if err != nil {
+		return nil, err
+	}
+	iter.CurrentHash = block.PrevHash
+	return block, nil
+}
 
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		HandleErr(err)
-		encodeBlock, err := item.Value()
-		block = Deserialize(encodeBlock)
-		return err
-	})
-	HandleErr(err)
+// NextHeader is like Next but it reads only the header of the block.
+func (iter *BlockChainIterator) NextHeader() (*Block, error) {
+	block, err := getHeader(iter.Database, iter.CurrentHash)
+	if err != nil {
+		return nil, err
+	}
 	iter.CurrentHash = block.PrevHash
-	return block
+	return block, nil
 }
 
 //DbExist check if the DB exist
-func DBExist() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+func DBExist(path string) bool {
+	if _, err := os.Stat(path + "/MANIFEST"); os.IsNotExist(err) {
 		return false
 	}
 	return true
 
 }
-func ContinueBlockChain(address string) *BlockChain {
-	if DBExist() == false {
-
-		fmt.Println("No existing Blockchain found, create one!")
-		runtime.Goexit()
 
+// ContinueBlockChain open the BlockChain inside the directory of opts, it
+// fails with ErrChainNotFound if there is no BlockChain.
+func ContinueBlockChain(opts Options) (*BlockChain, error) {
+	if opts.Store == nil && DBExist(opts.DBPath()) == false {
+		return nil, ErrChainNotFound
 	}
-	var lastHash []byte
-	//set path where to save data
-	opts := badger.DefaultOptions
-	opts.Dir = dbPath      // where the db store the keys and metadata
-	opts.ValueDir = dbPath //where the db will store all the values
 	//open the db
-	db, err := badger.Open(opts)
-	HandleErr(err)
+	db, err := openStore(opts)
+	if err != nil {
+		return nil, err
+	}
 
-	err = db.Update(func(txn *badger.Txn) error {
+	// if the blockchain has  already been stored into db
+	lastHash, err := db.Get([]byte("lh"))
+	if err == ErrKeyNotFound {
+		err = ErrChainNotFound
+	}
+	if err != nil {
+		if opts.Store == nil {
+			db.Close()
+		}
+		return nil, err
+	}
 
-		// if the blockchain has  already been stored into db
-		item, err := txn.Get([]byte("lh"))
-		HandleErr(err)
-		lastHash, err = item.Value()
-		return err
+	consensus, err := loadConsensus(db, opts)
+	if err != nil {
+		if opts.Store == nil {
+			db.Close()
+		}
+		return nil, err
+	}
 
-	})
+	chain := BlockChain{LastHash: lastHash, Database: db, Consensus: consensus}
+
+	//the chains of the old versions must be converted by MigrateEncoding
+	version, err := dbVersion(db)
+	if err == nil && version < DBVersion {
+		err = fmt.Errorf("%w: version %d", ErrOldDatabase, version)
+	}
+	if err == nil && version > DBVersion {
+		err = fmt.Errorf("Database version %d is newer than this program", version)
+	}
+	if err != nil {
+		if opts.Store == nil {
+			db.Close()
+		}
+		return nil, err
+	}
+
+	//the chains created before the indexes existed are indexed once
+	ok, err := chain.hasIndex()
+	if err == nil && !ok {
+		fmt.Println("Building the block index")
+		err = chain.ReindexBlocks()
+	}
+	if err != nil {
+		if opts.Store == nil {
+			db.Close()
+		}
+		return nil, err
+	}
 
-	HandleErr(err)
-	chain := BlockChain{LastHash: lastHash, Database: db}
-	return &chain
+	return &chain, nil
 
 }
 
 //FindUTXO go through all of the transactions and find all the unspent outputs in those transactions.
-func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
+func (chain *BlockChain) FindUTXO() (map[string]TxOutputs, error) {
 	UTXO := make(map[string]TxOutputs)
 	spentTXOs := make(map[string][]int)
 
@@ -181,7 +611,10 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 
 	//iterate over the blocks
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		for _, tx := range block.Transactions {
 			txID := hex.EncodeToString(tx.ID)
@@ -196,7 +629,10 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 					}
 				}
 				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
+				if outs.Outputs == nil {
+					outs.Outputs = make(map[int]TxOutput)
+				}
+				outs.Outputs[outIdx] = out
 				UTXO[txID] = outs
 			}
 			if tx.IsCoinbase() == false {
@@ -211,52 +647,92 @@ func (chain *BlockChain) FindUTXO() map[string]TxOutputs {
 			break
 		}
 	}
-	return UTXO
+	return UTXO, nil
 }
 
-//FindTransaction get an ID find the trans	action
+//FindTransaction get an ID find the transaction using the transaction index,
+//it fails with ErrTxNotFound if the transaction is not inside the main chain.
 func (bc *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
-	iter := bc.Iterator()
+	blockHash, position, err := bc.transactionLocation(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	block, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if position >= len(block.Transactions) || !bytes.Equal(block.Transactions[position].ID, ID) {
+		return Transaction{}, fmt.Errorf("Transaction index of %x is not valid, run reindex", ID)
+	}
 
-	for {
-		block := iter.Next()
+	return *block.Transactions[position], nil
+}
 
-		for _, tx := range block.Transactions {
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+//TransactionHeader return the header of the block of the main chain that
+//contains the transaction, it fails with ErrTxNotFound like FindTransaction.
+func (bc *BlockChain) TransactionHeader(ID []byte) (Block, error) {
+	blockHash, _, err := bc.transactionLocation(ID)
+	if err != nil {
+		return Block{}, err
+	}
 
-		if len(block.PrevHash) == 0 {
-			break
-		}
+	return bc.GetHeader(blockHash)
+}
+
+//transactionLocation return the hash of the block and the position of the
+//transaction saved by the transaction index.
+func (bc *BlockChain) transactionLocation(ID []byte) ([]byte, int, error) {
+	v, err := bc.Database.Get(txKey(ID))
+	if err == ErrKeyNotFound {
+		return nil, 0, fmt.Errorf("%w: %x", ErrTxNotFound, ID)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//the value is the hash of the block and 4 bytes of position
+	if len(v) < 4 {
+		return nil, 0, fmt.Errorf("Transaction index of %x is not valid", ID)
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return v[:len(v)-4], int(binary.BigEndian.Uint32(v[len(v)-4:])), nil
 }
 
-//SignTransaction sign a transaction
-func (bc *BlockChain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) {
+//SignTransaction sign the inputs of a transaction that the wallets can sign, see Transaction.Sign
+func (bc *BlockChain) SignTransaction(tx *Transaction, ws *wallet.Wallets) error {
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
 		prevTX, err := bc.FindTransaction(in.ID)
-		HandleErr(err)
+		if err != nil {
+			return err
+		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	tx.Sign(privKey, prevTXs)
+	return tx.Sign(ws, prevTXs)
 }
 
-//VerifyTransaction verify a transaction
-func (bc *BlockChain) VerifyTransaction(tx *Transaction) bool {
+//VerifyTransaction verify a transaction, it fails with ErrScriptFailed if the
+//scripts of the inputs don't unlock the outputs that the transaction spends.
+func (bc *BlockChain) VerifyTransaction(tx *Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range tx.Inputs {
 		prevTX, err := bc.FindTransaction(in.ID)
-		HandleErr(err)
+		if err != nil {
+			return err
+		}
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.Verify(prevTXs)
+	if err := tx.Verify(prevTXs); err != nil {
+		return fmt.Errorf("Transaction %x: %w", tx.ID, err)
+	}
+
+	return nil
 }
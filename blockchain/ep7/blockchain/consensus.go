@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// consensusKey save the Consensus of a chain that doesn't use the proof of
+// work, the chains without it use PoW.
+var consensusKey = []byte("consensus")
+
+// Consensus decide how the blocks are sealed and which sealed blocks are
+// valid, so the chain can use the proof of work or another consensus.
+type Consensus interface {
+	// Name return the name of the consensus, e.g. "PoW".
+	Name() string
+	// Prepare set the fields of the header of a new block that depend on the
+	// consensus, like the Bits of the proof of work. The block has already the
+	// other fields, chain is nil for the genesis block.
+	Prepare(chain *BlockChain, block *Block) error
+	// Seal set the Nonce, the Hash and the Seal of a prepared block so that
+	// Verify accepts it. It stops with the error of ctx when ctx is done.
+	Seal(ctx context.Context, block *Block) error
+	// Verify check the header and the Seal of a block that follows a block of
+	// chain, the transactions are not read so they can be missing. The rules
+	// that the block breaks are returned as ErrInvalidBlock.
+	Verify(chain *BlockChain, block *Block) error
+	// Work return the work of the block, the node follows the chain with the most work.
+	Work(block *Block) *big.Int
+}
+
+// PoW is the proof of work: the hash of the header must be lower than the
+// target of the Bits, that change every RetargetInterval blocks.
+type PoW struct{}
+
+// Name return "PoW".
+func (PoW) Name() string {
+	return "PoW"
+}
+
+// Prepare set the Bits that the block must have.
+func (PoW) Prepare(chain *BlockChain, block *Block) error {
+	if chain == nil || len(block.PrevHash) == 0 {
+		block.Bits = BigToCompact(powLimit)
+		return nil
+	}
+
+	bits, err := chain.RequiredBits(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	block.Bits = bits
+
+	return nil
+}
+
+// Seal mine the block with MineBlock.
+func (PoW) Seal(ctx context.Context, block *Block) error {
+	return MineBlock(ctx, block)
+}
+
+// Verify check the difficulty and the proof of work of the block.
+func (PoW) Verify(chain *BlockChain, block *Block) error {
+	if len(block.Seal) > 0 {
+		return invalidBlock("Block has a seal but the chain uses the proof of work")
+	}
+
+	return chain.CheckProofOfWork(block)
+}
+
+// Work return the number of hashes needed on average to mine the block.
+func (PoW) Work(block *Block) *big.Int {
+	return BlockWork(block.Bits)
+}
+
+// saveConsensus save the consensus of a new chain with w.
+func saveConsensus(w Writer, consensus Consensus) error {
+	switch c := consensus.(type) {
+	case PoW:
+		return nil
+	case *PoA:
+		if len(c.Authorities) == 0 {
+			return errNoAuthorities
+		}
+		e := newEncoder()
+		e.bytes([]byte(c.Name()))
+		e.uvarint(uint64(len(c.Authorities)))
+		for _, authority := range c.Authorities {
+			e.bytes(authority)
+		}
+		return w.Put(consensusKey, e.buf.Bytes())
+	default:
+		return fmt.Errorf("Consensus %s can't be saved", consensus.Name())
+	}
+}
+
+// loadConsensus return the consensus saved inside db, PoW if there is none.
+// The PoA signs the blocks with the keys of the wallets of opts.
+func loadConsensus(db Store, opts Options) (Consensus, error) {
+	v, err := db.Get(consensusKey)
+	if err == ErrKeyNotFound {
+		return PoW{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := newDecoder(v)
+	name := string(d.bytes())
+	var authorities [][]byte
+	for i, n := 0, d.count(); i < n; i++ {
+		authorities = append(authorities, d.bytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	if name != "PoA" {
+		return nil, fmt.Errorf("Consensus %q is not known", name)
+	}
+	if len(authorities) == 0 {
+		return nil, errNoAuthorities
+	}
+
+	return &PoA{Authorities: authorities, WalletFile: opts.WalletFile()}, nil
+}
@@ -0,0 +1,418 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The blocks, the transactions and the outputs are saved and sent with this
+// binary format. Every value has only one encoding, so the ID of a
+// transaction (the hash of its encoding) is the same on every node and
+// doesn't depend on Go.
+//
+//	byte      1 byte
+//	uvarint   unsigned number of encoding/binary, in the shortest form
+//	varint    signed number of encoding/binary (zig-zag), in the shortest form
+//	bytes     uvarint length followed by the bytes
+//	list X    uvarint number of items followed by the items
+//
+//	TxOutput     varint Value, bytes ScriptPubKey
+//	TxInput      bytes ID, varint Out, bytes ScriptSig, uvarint Sequence
+//	Transaction  uvarint Version, list TxInput, list TxOutput, uvarint LockTime, bytes ID
+//	BlockHeader  uvarint Version, bytes PrevHash, bytes MerkleRoot, bytes SigRoot,
+//	             varint Timestamp, uvarint Bits, varint Nonce
+//	Block        BlockHeader, bytes Hash, varint Height, bytes Seal, list Transaction
+//	TxOutputs    list (uvarint index, TxOutput), the indexes are increasing
+//	BlockUndo    list (bytes TxID, varint Index, TxOutput)
+//	PartialTransaction  Transaction, list Transaction
+//
+// The headers older than SigRootBlockVersion don't have the SigRoot. The
+// inputs of the transactions older than SequenceTxVersion don't have the
+// Sequence. The transactions older than ScriptTxVersion don't have the LockTime, and
+// their inputs and outputs have the fields used before the scripts:
+//
+//	TxOutput     varint Value, bytes PubKeyHash
+//	TxInput      bytes ID, varint Out, bytes Signature, bytes PubKey
+//
+// They are read with a P2PKH ScriptPubKey and a ScriptSig that pushes the
+// signature and the public key, and written back with the same bytes.
+//
+// The db saves the blocks in two values: the header with the height and the
+// seal (BlockHeader, varint Height, bytes Seal) and the body (list Transaction).
+//
+// The serialized data starts with a byte with EncodingVersion, the values
+// inside another value don't have it. The ID of a transaction is the
+// sha256 of its serialization with an empty ID and empty ScriptSigs, see
+// Transaction.Hash.
+const EncodingVersion = 1
+
+// encodingKey save the DBVersion of a db, the chains saved with gob by the
+// old versions don't have it.
+var encodingKey = []byte("encoding")
+
+// DBVersion is the version of the layout of the db: 1 is the binary format,
+// 2 saves the headers of the blocks apart from the bodies, 3 saves the Seal
+// of the blocks with the headers, 4 saves the outputs with the scripts.
+const DBVersion = 4
+
+// encoder write the values of the format into buf.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.buf.WriteByte(EncodingVersion)
+	return e
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	e.buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) output(out TxOutput) {
+	e.varint(int64(out.Value))
+	e.bytes(out.ScriptPubKey)
+}
+
+func (e *encoder) input(in TxInput, sequence bool) {
+	e.bytes(in.ID)
+	e.varint(int64(in.Out))
+	e.bytes(in.ScriptSig)
+	if sequence {
+		e.uvarint(uint64(in.Sequence))
+	}
+}
+
+// legacyOutput write an output of the transactions older than ScriptTxVersion.
+func (e *encoder) legacyOutput(out TxOutput) {
+	pubKeyHash, _ := P2PKHPubKeyHash(out.ScriptPubKey)
+	e.varint(int64(out.Value))
+	e.bytes(pubKeyHash)
+}
+
+// legacyInput write an input of the transactions older than ScriptTxVersion.
+func (e *encoder) legacyInput(in TxInput) {
+	signature, pubKey := sigScriptParts(in.ScriptSig)
+	e.bytes(in.ID)
+	e.varint(int64(in.Out))
+	e.bytes(signature)
+	e.bytes(pubKey)
+}
+
+func (e *encoder) transaction(tx *Transaction) {
+	legacy := tx.Version < ScriptTxVersion
+
+	e.uvarint(uint64(tx.Version))
+	e.uvarint(uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		if legacy {
+			e.legacyInput(in)
+		} else {
+			e.input(in, tx.Version >= SequenceTxVersion)
+		}
+	}
+	e.uvarint(uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		if legacy {
+			e.legacyOutput(out)
+		} else {
+			e.output(out)
+		}
+	}
+	if !legacy {
+		e.uvarint(uint64(tx.LockTime))
+	}
+	e.bytes(tx.ID)
+}
+
+func (e *encoder) header(h *BlockHeader) {
+	e.uvarint(uint64(h.Version))
+	e.bytes(h.PrevHash)
+	e.bytes(h.MerkleRoot)
+	if h.Version >= SigRootBlockVersion {
+		e.bytes(h.SigRoot)
+	}
+	e.varint(h.Timestamp)
+	e.uvarint(uint64(h.Bits))
+	e.varint(int64(h.Nonce))
+}
+
+func (e *encoder) transactions(txs []*Transaction) {
+	e.uvarint(uint64(len(txs)))
+	for _, tx := range txs {
+		e.transaction(tx)
+	}
+}
+
+func (e *encoder) block(b *Block) {
+	e.header(&b.BlockHeader)
+	e.bytes(b.Hash)
+	e.varint(int64(b.Height))
+	e.bytes(b.Seal)
+	e.transactions(b.Transactions)
+}
+
+// decoder read the values of the format from data, after the first error
+// every read returns a zero value and err keeps the error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+// newDecoder check the version byte of data.
+func newDecoder(data []byte) *decoder {
+	d := &decoder{data: data}
+	if v := d.byte(); d.err == nil && v != EncodingVersion {
+		d.fail("unknown version %d", v)
+	}
+	return d
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidEncoding, fmt.Sprintf(format, a...))
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail("bad uvarint")
+		return 0
+	}
+	//a longer form of the same number would give another hash
+	var tmp [binary.MaxVarintLen64]byte
+	if binary.PutUvarint(tmp[:], v) != n {
+		d.fail("uvarint is not in the shortest form")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail("bad varint")
+		return 0
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	if binary.PutVarint(tmp[:], v) != n {
+		d.fail("varint is not in the shortest form")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count read the length of a list or of bytes, every item takes at least one
+// byte so a length greater than the data left is not valid.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail("length %d is greater than the data", n)
+		return 0
+	}
+	return int(n)
+}
+
+// bytes return a copy of the bytes, nil if they are empty.
+func (d *decoder) bytes() []byte {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	b := append([]byte{}, d.data[:n]...)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) int() int {
+	return int(d.varint())
+}
+
+// uint32 read an uvarint that must fit in 32 bits, name is used by the error.
+func (d *decoder) uint32(name string) uint32 {
+	v := d.uvarint()
+	if v > math.MaxUint32 {
+		d.fail("%s %d is too big", name, v)
+	}
+	return uint32(v)
+}
+
+func (d *decoder) output() TxOutput {
+	return TxOutput{Value: d.int(), ScriptPubKey: d.bytes()}
+}
+
+func (d *decoder) input(sequence bool) TxInput {
+	in := TxInput{ID: d.bytes(), Out: d.int(), ScriptSig: d.bytes()}
+	if sequence {
+		in.Sequence = d.uint32("sequence")
+	}
+	return in
+}
+
+// legacyOutput read an output of the transactions older than ScriptTxVersion.
+func (d *decoder) legacyOutput() TxOutput {
+	return TxOutput{Value: d.int(), ScriptPubKey: P2PKHScript(d.bytes())}
+}
+
+// legacyInput read an input of the transactions older than ScriptTxVersion.
+func (d *decoder) legacyInput() TxInput {
+	in := TxInput{ID: d.bytes(), Out: d.int()}
+	in.ScriptSig = sigScript(d.bytes(), d.bytes())
+	return in
+}
+
+func (d *decoder) transaction() *Transaction {
+	tx := &Transaction{Version: int(d.uint32("transaction version"))}
+	legacy := tx.Version < ScriptTxVersion
+
+	for i, n := 0, d.count(); i < n; i++ {
+		if legacy {
+			tx.Inputs = append(tx.Inputs, d.legacyInput())
+		} else {
+			tx.Inputs = append(tx.Inputs, d.input(tx.Version >= SequenceTxVersion))
+		}
+	}
+	for i, n := 0, d.count(); i < n; i++ {
+		if legacy {
+			tx.Outputs = append(tx.Outputs, d.legacyOutput())
+		} else {
+			tx.Outputs = append(tx.Outputs, d.output())
+		}
+	}
+	if !legacy {
+		tx.LockTime = d.uint32("lock time")
+	}
+	tx.ID = d.bytes()
+
+	return tx
+}
+
+func (d *decoder) header() BlockHeader {
+	h := BlockHeader{
+		Version:    int(d.uint32("block version")),
+		PrevHash:   d.bytes(),
+		MerkleRoot: d.bytes(),
+	}
+	if h.Version >= SigRootBlockVersion {
+		h.SigRoot = d.bytes()
+	}
+	h.Timestamp = d.varint()
+	h.Bits = d.uint32("bits")
+	h.Nonce = d.int()
+
+	return h
+}
+
+func (d *decoder) transactions() []*Transaction {
+	var txs []*Transaction
+	for i, n := 0, d.count(); i < n; i++ {
+		txs = append(txs, d.transaction())
+	}
+
+	return txs
+}
+
+func (d *decoder) block() *Block {
+	return &Block{
+		BlockHeader:  d.header(),
+		Hash:         d.bytes(),
+		Height:       d.int(),
+		Seal:         d.bytes(),
+		Transactions: d.transactions(),
+	}
+}
+
+// finish return the error of the decoding, the data must be all read.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d bytes after the end", len(d.data))
+	}
+	return d.err
+}
+
+// outputs write the outputs ordered by index.
+func (e *encoder) outputs(outs TxOutputs) {
+	var indexes []int
+	for idx := range outs.Outputs {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	e.uvarint(uint64(len(indexes)))
+	for _, idx := range indexes {
+		e.uvarint(uint64(idx))
+		e.output(outs.Outputs[idx])
+	}
+}
+
+func (d *decoder) outputs() TxOutputs {
+	outs := TxOutputs{Outputs: make(map[int]TxOutput)}
+
+	last := -1
+	for i, n := 0, d.count(); i < n; i++ {
+		idx := int(d.uvarint())
+		if d.err == nil && idx <= last {
+			d.fail("output indexes are not increasing")
+		}
+		last = idx
+		outs.Outputs[idx] = d.output()
+	}
+
+	return outs
+}
+
+func (e *encoder) undo(undo BlockUndo) {
+	e.uvarint(uint64(len(undo.Spent)))
+	for _, spent := range undo.Spent {
+		e.bytes(spent.TxID)
+		e.varint(int64(spent.Index))
+		e.output(spent.Output)
+	}
+}
+
+func (d *decoder) undo() BlockUndo {
+	var undo BlockUndo
+	for i, n := 0, d.count(); i < n; i++ {
+		undo.Spent = append(undo.Spent, SpentOutput{TxID: d.bytes(), Index: d.int(), Output: d.output()})
+	}
+
+	return undo
+}
+
+func (e *encoder) partial(p *PartialTransaction) {
+	e.transaction(p.Tx)
+	e.transactions(p.PrevTXs)
+}
+
+func (d *decoder) partial() *PartialTransaction {
+	return &PartialTransaction{Tx: d.transaction(), PrevTXs: d.transactions()}
+}
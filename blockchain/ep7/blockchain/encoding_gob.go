@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"io/ioutil"
+)
+
+// LegacyTxVersion is the Version of the transactions created before the
+// binary format, when the ID was the hash of the gob encoding. They are kept
+// by the migration with their ID and their signatures.
+const LegacyTxVersion = 0
+
+// legacyTxHash return the hash of a transaction with the gob encoding used
+// before the binary format, it is needed to verify the signatures of the
+// transactions with LegacyTxVersion. It returns nil if gob fails, then the
+// transaction doesn't match its ID and it is not valid.
+var legacyTxHash = newLegacyTxHash()
+
+// currentTransaction is Transaction inside newLegacyTxHash, where the name is
+// used by the frozen type.
+type currentTransaction = Transaction
+
+// newLegacyTxHash freeze the types that were encoded with gob. gob give a
+// number to every type the first time that the type is encoded and this
+// number is written inside the encoded bytes, so the types are encoded here
+// first, like the old versions did, to get the same numbers and the same hashes.
+func newLegacyTxHash() func(tx *currentTransaction) []byte {
+	//the names must be the same of the old types, gob writes them
+	type TxOutput struct {
+		Value      int
+		PubKeyHash []byte
+	}
+	type TxInput struct {
+		ID        []byte
+		Out       int
+		Signature []byte
+		PubKey    []byte
+	}
+	type Transaction struct {
+		ID      []byte
+		Inputs  []TxInput
+		Outputs []TxOutput
+	}
+
+	//gob doesn't fail with these types, if it does the legacy transactions
+	//get a nil hash, that is not the ID of any transaction
+	if err := gob.NewEncoder(ioutil.Discard).Encode(Transaction{}); err != nil {
+		return func(tx *currentTransaction) []byte { return nil }
+	}
+
+	return func(tx *currentTransaction) []byte {
+		legacy := Transaction{} //the ID is not part of the hash
+		for _, in := range tx.Inputs {
+			signature, pubKey := sigScriptParts(in.ScriptSig)
+			legacy.Inputs = append(legacy.Inputs, TxInput{in.ID, in.Out, signature, pubKey})
+		}
+		for _, out := range tx.Outputs {
+			pubKeyHash, _ := P2PKHPubKeyHash(out.ScriptPubKey)
+			legacy.Outputs = append(legacy.Outputs, TxOutput{out.Value, pubKeyHash})
+		}
+
+		var encoded bytes.Buffer
+		if err := gob.NewEncoder(&encoded).Encode(legacy); err != nil {
+			return nil
+		}
+
+		hash := sha256.Sum256(encoded.Bytes())
+		return hash[:]
+	}
+}
+
+// DeserializeGobBlock decode a block saved with gob by the old versions, the
+// transactions get LegacyTxVersion and the header LegacyBlockVersion because
+// gob doesn't know the versions. The blocks of the first version, without the
+// Timestamp, are hashed like that version did (see BlockHeader.hashData).
+func DeserializeGobBlock(data []byte) (*Block, error) {
+	//the old transactions didn't have the scripts
+	type oldTransaction struct {
+		ID     []byte
+		Inputs []struct {
+			ID        []byte
+			Out       int
+			Signature []byte
+			PubKey    []byte
+		}
+		Outputs []struct {
+			Value      int
+			PubKeyHash []byte
+		}
+	}
+	//the old blocks had the fields of the header inside the block
+	var old struct {
+		Hash         []byte
+		Transactions []*oldTransaction
+		PrevHash     []byte
+		Nonce        int
+		Height       int
+		Timestamp    int64
+		Bits         uint32
+		MerkleRoot   []byte
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&old); err != nil {
+		return nil, err
+	}
+
+	var txs []*Transaction
+	for _, oldTx := range old.Transactions {
+		tx := &Transaction{ID: oldTx.ID, Version: LegacyTxVersion}
+		for _, in := range oldTx.Inputs {
+			tx.Inputs = append(tx.Inputs, TxInput{ID: in.ID, Out: in.Out, ScriptSig: sigScript(in.Signature, in.PubKey)})
+		}
+		for _, out := range oldTx.Outputs {
+			tx.Outputs = append(tx.Outputs, TxOutput{out.Value, P2PKHScript(out.PubKeyHash)})
+		}
+		txs = append(txs, tx)
+	}
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:    LegacyBlockVersion,
+			PrevHash:   old.PrevHash,
+			MerkleRoot: old.MerkleRoot,
+			Timestamp:  old.Timestamp,
+			Bits:       old.Bits,
+			Nonce:      old.Nonce,
+		},
+		Hash:         old.Hash,
+		Height:       old.Height,
+		Transactions: txs,
+	}
+
+	//the blocks of the first version had only the hash, the transactions, the
+	//PrevHash and the Nonce: the target was always the powLimit and the
+	//MerkleRoot was calculated by the proof of work. The Height is set by
+	//MigrateEncoding.
+	if old.Timestamp == 0 {
+		block.Bits = BigToCompact(powLimit)
+		block.MerkleRoot = block.HashTransactions()
+	}
+
+	return block, nil
+}
@@ -0,0 +1,187 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// testTransactions return a transaction of every version, the inputs and the
+// outputs of the old versions have the P2PKH scripts that they can encode.
+func testTransactions(t *testing.T) []*Transaction {
+	w := makeWallets(t, 1)[0]
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	signature := bytes.Repeat([]byte{7}, 64)
+
+	var txs []*Transaction
+	for _, version := range []int{LegacyTxVersion, BinaryTxVersion, ScriptTxVersion, SequenceTxVersion} {
+		tx := &Transaction{
+			Inputs: []TxInput{
+				{ID: bytes.Repeat([]byte{1}, 32), Out: 0, ScriptSig: sigScript(signature, w.PublicKey)},
+				{ID: bytes.Repeat([]byte{2}, 32), Out: 300, ScriptSig: sigScript(signature, w.PublicKey)},
+			},
+			Outputs: []TxOutput{
+				{Value: 5, ScriptPubKey: P2PKHScript(pubKeyHash)},
+				{Value: 1 << 40, ScriptPubKey: P2PKHScript(pubKeyHash)},
+			},
+			Version: version,
+		}
+		if version >= ScriptTxVersion {
+			tx.LockTime = LockTimeThreshold + 1
+			tx.Outputs = append(tx.Outputs, TxOutput{Value: 0, ScriptPubKey: []byte{OpReturn, 1, 42}})
+		}
+		if version >= SequenceTxVersion {
+			tx.Inputs[1].Sequence = SequenceTypeFlag | 10
+		}
+		tx.SetID()
+		txs = append(txs, tx)
+	}
+
+	coinbase, err := CoinbaseTx(string(w.Address()), "data", 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the empty bytes are read as nil
+	coinbase.Inputs[0].ID = nil
+
+	return append(txs, coinbase)
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	for _, tx := range testTransactions(t) {
+		data := tx.Serialize()
+		read, err := DeserializeTransaction(data)
+		if err != nil {
+			t.Fatalf("version %d: %v", tx.Version, err)
+		}
+		if !reflect.DeepEqual(&read, tx) {
+			t.Errorf("version %d: the transaction %v is read as %v", tx.Version, tx, read)
+		}
+		if !bytes.Equal(read.Hash(), tx.ID) {
+			t.Errorf("version %d: the ID changed after the encoding", tx.Version)
+		}
+	}
+}
+
+func TestBlockEncoding(t *testing.T) {
+	txs := testTransactions(t)
+	for _, version := range []int{LegacyBlockVersion, BinaryBlockVersion, SigRootBlockVersion} {
+		block := newBlock(txs, bytes.Repeat([]byte{3}, 32), 12, 1600000000, 0x1f00ffff)
+		block.Version = version
+		block.SigRoot = block.HashSignatures()
+		block.Nonce = 1 << 33
+		block.Hash = block.BlockHash()
+		block.Seal = []byte("seal")
+
+		read, err := Deserialize(block.Serialize())
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if !reflect.DeepEqual(read, block) {
+			t.Errorf("version %d: the block %+v is read as %+v", version, block, read)
+		}
+
+		header, err := DeserializeHeader(block.BlockHeader.Serialize())
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if !reflect.DeepEqual(*header, block.BlockHeader) {
+			t.Errorf("version %d: the header %+v is read as %+v", version, block.BlockHeader, *header)
+		}
+		if !bytes.Equal(header.BlockHash(), block.Hash) {
+			t.Errorf("version %d: the hash changed after the encoding", version)
+		}
+	}
+}
+
+func TestOutputsEncoding(t *testing.T) {
+	outs := TxOutputs{Outputs: map[int]TxOutput{
+		0:   {Value: 1, ScriptPubKey: P2PKHScript(bytes.Repeat([]byte{4}, 20))},
+		3:   {Value: 1 << 50, ScriptPubKey: P2SHScript(bytes.Repeat([]byte{5}, 20))},
+		200: {Value: 0},
+	}}
+	read, err := DeserializeOutputs(outs.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, outs) {
+		t.Errorf("the outputs %v are read as %v", outs, read)
+	}
+
+	undo := BlockUndo{Spent: []SpentOutput{
+		{TxID: bytes.Repeat([]byte{6}, 32), Index: 2, Output: outs.Outputs[3]},
+		{TxID: bytes.Repeat([]byte{7}, 32), Index: 0, Output: outs.Outputs[0]},
+	}}
+	readUndo, err := DeserializeUndo(undo.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(readUndo, undo) {
+		t.Errorf("the undo data %v is read as %v", undo, readUndo)
+	}
+}
+
+func TestEncodingNotValid(t *testing.T) {
+	tx := testTransactions(t)[3]
+	txData := tx.Serialize()
+	block := newBlock([]*Transaction{tx}, nil, 1, 1, 0)
+	blockData := block.Serialize()
+
+	decodeTx := func(data []byte) error {
+		_, err := DeserializeTransaction(data)
+		return err
+	}
+	decodeBlock := func(data []byte) error {
+		_, err := Deserialize(data)
+		return err
+	}
+	decodeHeader := func(data []byte) error {
+		_, err := DeserializeHeader(data)
+		return err
+	}
+	decodeOutputs := func(data []byte) error {
+		_, err := DeserializeOutputs(data)
+		return err
+	}
+	decodeUndo := func(data []byte) error {
+		_, err := DeserializeUndo(data)
+		return err
+	}
+
+	//the outputs {1: {Value 1, empty script}} are EncodingVersion, 1, 1, 2, 0
+	tests := []struct {
+		name   string
+		decode func([]byte) error
+		data   []byte
+	}{
+		{"empty", decodeTx, nil},
+		{"unknown encoding version", decodeTx, append([]byte{EncodingVersion + 1}, txData[1:]...)},
+		{"transaction with a byte after the end", decodeTx, append(append([]byte{}, txData...), 0)},
+		{"truncated transaction", decodeTx, txData[:len(txData)-1]},
+		{"block with a byte after the end", decodeBlock, append(append([]byte{}, blockData...), 0)},
+		{"truncated block", decodeBlock, blockData[:len(blockData)-1]},
+		{"header with a byte after the end", decodeHeader, append(block.BlockHeader.Serialize(), 0)},
+		{"outputs with a byte after the end", decodeOutputs, []byte{EncodingVersion, 1, 1, 2, 0, 0}},
+		{"undo with a byte after the end", decodeUndo, append(BlockUndo{}.Serialize(), 0)},
+		{"uvarint not in the shortest form", decodeOutputs, []byte{EncodingVersion, 1, 0x81, 0, 2, 0}},
+		{"varint not in the shortest form", decodeOutputs, []byte{EncodingVersion, 1, 1, 0x82, 0, 0}},
+		{"uvarint of the version not in the shortest form", decodeTx, append([]byte{EncodingVersion, 0x80 | txData[1], 0}, txData[2:]...)},
+		{"uvarint longer than 64 bits", decodeOutputs, []byte{EncodingVersion, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 2, 0}},
+		{"output indexes not increasing", decodeOutputs, []byte{EncodingVersion, 2, 1, 2, 0, 1, 2, 0}},
+		{"length greater than the data", decodeOutputs, []byte{EncodingVersion, 1, 1, 2, 5, 0}},
+		{"block version of more than 32 bits", decodeHeader, []byte{EncodingVersion, 0x80, 0x80, 0x80, 0x80, 0x10, 0, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		if err := test.decode(test.data); !errors.Is(err, ErrInvalidEncoding) {
+			t.Errorf("%s: error %v, want ErrInvalidEncoding", test.name, err)
+		}
+	}
+
+	//the data of the tests without the change is valid
+	if _, err := DeserializeOutputs([]byte{EncodingVersion, 1, 1, 2, 0}); err != nil {
+		t.Errorf("the outputs used by the tests are not valid: %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package blockchain
+
+import "errors"
+
+// Errors returned by the blockchain package, the callers can check them with errors.Is.
+var (
+	ErrChainNotFound     = errors.New("No existing blockchain found, create one!")
+	ErrChainExists       = errors.New("Blockchain already exists")
+	ErrBlockNotFound     = errors.New("Block is not found")
+	ErrTxNotFound        = errors.New("Transaction does not exist")
+	ErrInsufficientFunds = errors.New("Not enough funds")
+	ErrScriptFailed      = errors.New("Script failed")
+	ErrInvalidBlock      = errors.New("Block is not valid")
+	ErrInvalidTx         = errors.New("Transaction is not valid")
+	ErrOldDatabase       = errors.New("Database was created by an old version, run migratedb")
+	ErrNoAddressIndex    = errors.New("Address index is not enabled, run reindex -addrindex")
+	ErrInvalidEncoding   = errors.New("Data is not encoded correctly")
+	ErrNonceExhausted    = errors.New("No nonce meets the target")
+)
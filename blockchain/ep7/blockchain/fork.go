@@ -0,0 +1,255 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	workPrefix    = []byte("work-")    //prefix of the total work of the chain that ends with a block
+	invalidPrefix = []byte("invalid-") //prefix of the blocks rejected by a reorganization, they can't be imported again
+)
+
+// ChainWork return the total work of the chain that ends with the block hash,
+// the node follow the chain with the most work.
+func (chain *BlockChain) ChainWork(hash []byte) (*big.Int, error) {
+	v, err := chain.Database.Get(append(workPrefix, hash...))
+	if err == nil {
+		return new(big.Int).SetBytes(v), nil
+	}
+	if err != ErrKeyNotFound {
+		return nil, err
+	}
+
+	//the work is not saved, we calculate it from the headers
+	block, err := chain.GetHeader(hash)
+	if err != nil {
+		return nil, err
+	}
+	work := chain.Consensus.Work(&block)
+	if len(block.PrevHash) > 0 {
+		prevWork, err := chain.ChainWork(block.PrevHash)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, prevWork)
+	}
+
+	return work, nil
+}
+
+// connectBlock make block, that follows the last block, the last block of the
+// chain: the UTXOSet is updated and the last hash is saved in the same batch.
+func (chain *BlockChain) connectBlock(block *Block) error {
+	UTXOSet := UTXOSet{Blockchain: chain}
+	err := chain.Database.Batch(func(batch Batch) error {
+		if err := UTXOSet.update(batch, block); err != nil {
+			return err
+		}
+
+		return batch.Put([]byte("lh"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.Hash
+	return nil
+}
+
+// disconnectBlock is the opposite of connectBlock, the block before the last
+// block become the last block.
+func (chain *BlockChain) disconnectBlock(block *Block) error {
+	UTXOSet := UTXOSet{Blockchain: chain}
+	err := chain.Database.Batch(func(batch Batch) error {
+		if err := UTXOSet.disconnect(batch, block); err != nil {
+			return err
+		}
+
+		return batch.Put([]byte("lh"), block.PrevHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	chain.LastHash = block.PrevHash
+	return nil
+}
+
+// findFork return the blocks of the old chain that must be disconnected (from
+// the last to the first) and the blocks of the new chain that must be
+// connected (from the first to the last) to move from oldTip to newTip.
+func (chain *BlockChain) findFork(oldTip, newTip *Block) ([]*Block, []*Block, error) {
+	var disconnect, connect []*Block
+
+	parent := func(block *Block) (*Block, error) {
+		prev, err := chain.GetBlock(block.PrevHash)
+		return &prev, err
+	}
+
+	var err error
+	oldBlock, newBlock := oldTip, newTip
+	for oldBlock.Height > newBlock.Height {
+		disconnect = append(disconnect, oldBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for newBlock.Height > oldBlock.Height {
+		connect = append(connect, newBlock)
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		disconnect = append(disconnect, oldBlock)
+		connect = append(connect, newBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, err
+		}
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, j := 0, len(connect)-1; i < j; i, j = i+1, j-1 {
+		connect[i], connect[j] = connect[j], connect[i]
+	}
+
+	return disconnect, connect, nil
+}
+
+// Reorganize move the chain from the current last block to newTip, that is on
+// another branch with more work. The blocks of the current branch are
+// disconnected from the UTXOSet using their undo data and the blocks of the
+// new branch are validated and connected. If something fails the chain go
+// back to the old branch, and if a block of the new branch is not valid it is
+// removed with the blocks after it, so its work doesn't count anymore. The
+// other errors (e.g. of the db) don't remove the blocks. The Mempool of the chain get back
+// the transactions of the disconnected blocks.
+func (chain *BlockChain) Reorganize(newTip *Block) error {
+	UTXOSet := UTXOSet{Blockchain: chain}
+
+	oldTip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return err
+	}
+
+	disconnect, connect, err := chain.findFork(&oldTip, newTip)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Reorganization: disconnect %d blocks, connect %d blocks\n", len(disconnect), len(connect))
+
+	//the blocks saved before the undo data existed can't be disconnected, so
+	//we stay on the old branch: the new one can't be validated
+	for _, block := range disconnect {
+		ok, err := UTXOSet.HasUndo(block)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("Reorganization failed, block %x has no undo data and can't be disconnected", block.Hash)
+		}
+	}
+
+	//after a failure the chain go back to the old branch, the error is not
+	//nil only if that fails too
+	undo := func(err error, connected, disconnected []*Block) error {
+		if rollbackErr := chain.rollback(connected, disconnected); rollbackErr != nil {
+			return fmt.Errorf("Reorganization failed: %w, and the chain can't go back to block %x: %w", err, oldTip.Hash, rollbackErr)
+		}
+		return nil
+	}
+
+	for i, block := range disconnect {
+		if err := chain.disconnectBlock(block); err != nil {
+			if undoErr := undo(err, nil, disconnect[:i]); undoErr != nil {
+				return undoErr
+			}
+			return fmt.Errorf("Reorganization failed, block %x can't be disconnected: %w", block.Hash, err)
+		}
+	}
+
+	for i, block := range connect {
+		if err := chain.ValidateBlock(block); err != nil {
+			if undoErr := undo(err, connect[:i], disconnect); undoErr != nil {
+				return undoErr
+			}
+			//only the rules that the block breaks make it invalid, not e.g. an error of the db
+			if errors.Is(err, ErrInvalidBlock) {
+				if err := chain.removeInvalid(connect[i:]); err != nil {
+					return err
+				}
+			}
+
+			return fmt.Errorf("Reorganization failed, block %x: %w", block.Hash, err)
+		}
+
+		if err := chain.connectBlock(block); err != nil {
+			if undoErr := undo(err, connect[:i], disconnect); undoErr != nil {
+				return undoErr
+			}
+			return fmt.Errorf("Reorganization failed, block %x can't be connected: %w", block.Hash, err)
+		}
+	}
+
+	if chain.Mempool != nil {
+		chain.Mempool.Reorganize(disconnect)
+	}
+	return nil
+}
+
+// removeInvalid delete the blocks that are not valid, with their work, and
+// remember their hashes so ImportBlock rejects them. The hash of a block older
+// than SigRootBlockVersion doesn't cover the ScriptSigs, the node that sent it
+// could have changed them, so it is only deleted and the real block can be
+// imported again.
+func (chain *BlockChain) removeInvalid(blocks []*Block) error {
+	return chain.Database.Batch(func(batch Batch) error {
+		for _, block := range blocks {
+			for _, prefix := range [][]byte{headerPrefix, bodyPrefix, workPrefix} {
+				if err := batch.Delete(append(append([]byte{}, prefix...), block.Hash...)); err != nil {
+					return err
+				}
+			}
+			if block.Version < SigRootBlockVersion {
+				continue
+			}
+			if err := batch.Put(append(append([]byte{}, invalidPrefix...), block.Hash...), []byte{1}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// isInvalid check if the block has been removed by a reorganization because it is not valid.
+func (chain *BlockChain) isInvalid(hash []byte) (bool, error) {
+	_, err := chain.Database.Get(append(append([]byte{}, invalidPrefix...), hash...))
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// rollback disconnect the blocks of the new branch that are already connected
+// and connect again the blocks of the old branch.
+func (chain *BlockChain) rollback(connected, disconnected []*Block) error {
+	for j := len(connected) - 1; j >= 0; j-- {
+		if err := chain.disconnectBlock(connected[j]); err != nil {
+			return err
+		}
+	}
+	for j := len(disconnected) - 1; j >= 0; j-- {
+		if err := chain.connectBlock(disconnected[j]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,422 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// newTestChain create a chain inside a temp Badger db, the genesis reward is
+// sent to a new address of ws.
+func newTestChain(t *testing.T, ws *wallet.Wallets) (*BlockChain, string) {
+	address, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions("test")
+	opts.DataDir = t.TempDir()
+	chain, err := InitBlockChain(address, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { chain.Database.Close() })
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, address
+}
+
+// sideBlock mine an empty block after prev that pays the reward to miner,
+// prev doesn't need to be the last block.
+func sideBlock(t *testing.T, chain *BlockChain, prev *Block, miner string) *Block {
+	coinbase, err := CoinbaseTx(miner, "", prev.Height+1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := newBlock([]*Transaction{coinbase}, prev.Hash, prev.Height+1, prev.Timestamp+1, 0)
+	if err := chain.Consensus.Prepare(chain, block); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Consensus.Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	return block
+}
+
+// balance return the balance of address inside the UTXOSet of chain.
+func balance(t *testing.T, chain *BlockChain, address string) int {
+	script, err := AddressScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	outs, err := UTXOSet.FindUTXO(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, out := range outs {
+		total += out.Value
+	}
+	return total
+}
+
+func TestReorganize(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, a := newTestChain(t, ws)
+	b, _ := ws.AddWallet()
+	c, _ := ws.AddWallet()
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the tip branch: a send 10 to b
+	w, err := ws.GetWallet(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(&w, b, 10, 0, TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.AddBlock(a, []*Transaction{tx}); err != nil {
+		t.Fatal(err)
+	}
+	if got := balance(t, chain, b); got != 10 {
+		t.Fatalf("balance of b before the reorganization is %d, want 10", got)
+	}
+
+	//the side branch is longer and pays c
+	s1 := sideBlock(t, chain, &genesis, c)
+	if err := chain.ImportBlock(s1); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(chain.LastHash, s1.Hash) {
+		t.Fatal("a side block with the same work replaced the last block")
+	}
+	s2 := sideBlock(t, chain, s1, c)
+	if err := chain.ImportBlock(s2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(chain.LastHash, s2.Hash) {
+		t.Fatalf("last block is %x, want the side block %x", chain.LastHash, s2.Hash)
+	}
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 2 {
+		t.Fatalf("height is %d, want 2", height)
+	}
+
+	if _, err := chain.FindTransaction(tx.ID); !errors.Is(err, ErrTxNotFound) {
+		t.Fatalf("FindTransaction of the reverted transaction = %v, want ErrTxNotFound", err)
+	}
+
+	want := map[string]int{a: BlockSubsidy(0), b: 0, c: BlockSubsidy(1) + BlockSubsidy(2)}
+	for address, value := range want {
+		if got := balance(t, chain, address); got != value {
+			t.Errorf("balance of %s is %d, want %d", address, got, value)
+		}
+	}
+}
+
+func TestReorganizeInvalidBranch(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, a := newTestChain(t, ws)
+	b, _ := ws.AddWallet()
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := chain.AddBlock(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the side branch has more work but it spends more than the genesis reward
+	w, err := ws.GetWallet(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(&w, b, 10, 0, TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Outputs[0].Value = 1000
+	if err := chain.SignTransaction(tx, ws); err != nil {
+		t.Fatal(err)
+	}
+	tx.SetID()
+
+	coinbase, err := CoinbaseTx(b, "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newBlock([]*Transaction{coinbase, tx}, genesis.Hash, 1, genesis.Timestamp+1, 0)
+	if err := chain.Consensus.Prepare(chain, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Consensus.Seal(context.Background(), s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.ImportBlock(s1); err != nil {
+		t.Fatal(err)
+	}
+	s2 := sideBlock(t, chain, s1, b)
+	if err := chain.ImportBlock(s2); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("ImportBlock of the invalid branch = %v, want ErrInvalidBlock", err)
+	}
+
+	if !bytes.Equal(chain.LastHash, last.Hash) {
+		t.Fatalf("last block is %x, want %x", chain.LastHash, last.Hash)
+	}
+	for _, block := range []*Block{s1, s2} {
+		if _, err := chain.GetBlock(block.Hash); !errors.Is(err, ErrBlockNotFound) {
+			t.Errorf("invalid block %x is still saved: %v", block.Hash, err)
+		}
+	}
+	if err := chain.ImportBlock(s1); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("ImportBlock of a rejected block = %v, want ErrInvalidBlock", err)
+	}
+	if got := balance(t, chain, a); got != BlockSubsidy(0)+BlockSubsidy(1) {
+		t.Errorf("balance of a is %d, want %d", got, BlockSubsidy(0)+BlockSubsidy(1))
+	}
+}
+
+func TestImportStrippedSignatures(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, a := newTestChain(t, ws)
+	c, _ := ws.AddWallet()
+
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the side block s1 contains a transaction signed by a that spends the genesis reward
+	w, err := ws.GetWallet(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(&w, c, 10, 0, TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.AddBlock(a, nil); err != nil {
+		t.Fatal(err)
+	}
+	coinbase, err := CoinbaseTx(c, "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newBlock([]*Transaction{coinbase, tx}, genesis.Hash, 1, genesis.Timestamp+1, 0)
+	if err := chain.Consensus.Prepare(chain, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Consensus.Seal(context.Background(), s1); err != nil {
+		t.Fatal(err)
+	}
+
+	//a node relays s1 without the signatures, the ID of tx and the header don't change
+	strippedTx := *tx
+	strippedTx.Inputs = append([]TxInput{}, tx.Inputs...)
+	for i := range strippedTx.Inputs {
+		strippedTx.Inputs[i].ScriptSig = nil
+	}
+	stripped := *s1
+	stripped.Transactions = []*Transaction{coinbase, &strippedTx}
+	if !bytes.Equal(stripped.BlockHash(), s1.Hash) || !bytes.Equal(strippedTx.Hash(), tx.ID) {
+		t.Fatal("the stripped block doesn't have the hash of s1")
+	}
+	if err := chain.ImportBlock(&stripped); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("ImportBlock of the stripped block = %v, want ErrInvalidBlock", err)
+	}
+
+	//the real block is still accepted and its branch can become the main chain
+	if err := chain.ImportBlock(s1); err != nil {
+		t.Fatal(err)
+	}
+	s2 := sideBlock(t, chain, s1, c)
+	if err := chain.ImportBlock(s2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, s2.Hash) {
+		t.Fatalf("last block is %x, want the side block %x", chain.LastHash, s2.Hash)
+	}
+	if got, want := balance(t, chain, c), 10+BlockSubsidy(1)+BlockSubsidy(2); got != want {
+		t.Errorf("balance of c is %d, want %d", got, want)
+	}
+}
+
+// faultyStore is a Store whose Get and Batch fail while fail return an error,
+// like a disk with transient errors.
+type faultyStore struct {
+	Store
+	fail func(key []byte) error //key is nil for a Batch
+}
+
+func (s *faultyStore) Get(key []byte) ([]byte, error) {
+	if s.fail != nil {
+		if err := s.fail(key); err != nil {
+			return nil, err
+		}
+	}
+	return s.Store.Get(key)
+}
+
+func (s *faultyStore) Batch(fn func(batch Batch) error) error {
+	if s.fail != nil {
+		if err := s.fail(nil); err != nil {
+			return err
+		}
+	}
+	return s.Store.Batch(fn)
+}
+
+// newFaultyBranches create a chain inside a faultyStore with the tip branch
+// genesis-t1 and the side branch genesis-s1, s1 spends the genesis reward.
+// It returns the chain, the store, t1, s1 and the address of the genesis.
+func newFaultyBranches(t *testing.T, ws *wallet.Wallets) (*BlockChain, *faultyStore, *Block, *Block, string) {
+	a, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &faultyStore{Store: NewMemoryStore()}
+	opts := DefaultOptions("test")
+	opts.Store = store
+	chain, err := InitBlockChain(a, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ws.GetWallet(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewTransaction(&w, a, 10, 0, TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1, err := chain.AddBlock(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coinbase, err := CoinbaseTx(a, "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s1 := newBlock([]*Transaction{coinbase, tx}, genesis.Hash, 1, genesis.Timestamp+1, 0)
+	if err := chain.Consensus.Prepare(chain, s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Consensus.Seal(context.Background(), s1); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.ImportBlock(s1); err != nil {
+		t.Fatal(err)
+	}
+
+	return chain, store, t1, s1, a
+}
+
+// checkOldBranch check that the chain is still on the branch of t1 and that
+// the blocks of the side branch are kept.
+func checkOldBranch(t *testing.T, chain *BlockChain, t1 *Block, side []*Block, a string) {
+	if !bytes.Equal(chain.LastHash, t1.Hash) {
+		t.Fatalf("last block is %x, want %x", chain.LastHash, t1.Hash)
+	}
+	if got := balance(t, chain, a); got != BlockSubsidy(0)+BlockSubsidy(1) {
+		t.Errorf("balance of a is %d, want %d", got, BlockSubsidy(0)+BlockSubsidy(1))
+	}
+	for _, block := range side {
+		if _, err := chain.GetBlock(block.Hash); err != nil {
+			t.Errorf("side block %x has been removed: %v", block.Hash, err)
+		}
+		if invalid, err := chain.isInvalid(block.Hash); err != nil || invalid {
+			t.Errorf("side block %x is marked invalid", block.Hash)
+		}
+	}
+}
+
+func TestReorganizeStoreError(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, store, t1, s1, a := newFaultyBranches(t, ws)
+	b, _ := ws.AddWallet()
+
+	//the UTXO set can't be read while s1 is validated
+	errDisk := errors.New("disk error")
+	store.fail = func(key []byte) error {
+		if bytes.HasPrefix(key, utxoPrefix) {
+			return errDisk
+		}
+		return nil
+	}
+	s2 := sideBlock(t, chain, s1, b)
+	if err := chain.ImportBlock(s2); !errors.Is(err, errDisk) || errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("ImportBlock with a disk error = %v, want the disk error", err)
+	}
+	store.fail = nil
+	checkOldBranch(t, chain, t1, []*Block{s1, s2}, a)
+
+	//the branch is valid and the next block moves the chain on it
+	s3 := sideBlock(t, chain, s2, b)
+	if err := chain.ImportBlock(s3); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(chain.LastHash, s3.Hash) {
+		t.Fatalf("last block is %x, want the side block %x", chain.LastHash, s3.Hash)
+	}
+}
+
+func TestReorganizeConnectError(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, store, t1, s1, a := newFaultyBranches(t, ws)
+	b, _ := ws.AddWallet()
+
+	//the batches of ImportBlock(s2) save s2, disconnect t1, connect s1 and connect s2
+	errDisk := errors.New("disk error")
+	batches := 0
+	store.fail = func(key []byte) error {
+		if key == nil {
+			batches++
+			if batches == 4 {
+				return errDisk
+			}
+		}
+		return nil
+	}
+	s2 := sideBlock(t, chain, s1, b)
+	if err := chain.ImportBlock(s2); !errors.Is(err, errDisk) {
+		t.Fatalf("ImportBlock with a disk error = %v, want the disk error", err)
+	}
+	store.fail = nil
+	checkOldBranch(t, chain, t1, []*Block{s1, s2}, a)
+}
@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var (
+	heightPrefix = []byte("h-")  //prefix of the hash of the block of the main chain at a height
+	txPrefix     = []byte("tx-") //prefix of the block (hash and position) that contains a transaction
+)
+
+// heightKey return the key of the index of height, the height is big endian
+// so the keys are in the order of the chain.
+func heightKey(height int) []byte {
+	key := make([]byte, len(heightPrefix)+8)
+	copy(key, heightPrefix)
+	binary.BigEndian.PutUint64(key[len(heightPrefix):], uint64(height))
+
+	return key
+}
+
+// txKey return the key of the index of the transaction txID.
+func txKey(txID []byte) []byte {
+	return append(append([]byte{}, txPrefix...), txID...)
+}
+
+// txLocation is the value of the transaction index: the hash of the block and
+// the position of the transaction inside the block.
+func txLocation(blockHash []byte, position int) []byte {
+	value := make([]byte, len(blockHash)+4)
+	copy(value, blockHash)
+	binary.BigEndian.PutUint32(value[len(blockHash):], uint32(position))
+
+	return value
+}
+
+// indexBlock add the block to the indexes of the main chain.
+func indexBlock(w Writer, block *Block) error {
+	if err := w.Put(heightKey(block.Height), block.Hash); err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		if err := w.Put(txKey(tx.ID), txLocation(block.Hash, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexBlock remove the block from the indexes of the main chain, the keys
+// that point to another block are not changed.
+func unindexBlock(batch Batch, block *Block) error {
+	for _, tx := range block.Transactions {
+		v, err := batch.Get(txKey(tx.ID))
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(v, block.Hash) {
+			if err := batch.Delete(txKey(tx.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	v, err := batch.Get(heightKey(block.Height))
+	if err == ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(v, block.Hash) {
+		return nil
+	}
+
+	return batch.Delete(heightKey(block.Height))
+}
+
+// GetBlockByHeight return the block of the main chain at height.
+func (chain *BlockChain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := chain.Database.Get(heightKey(height))
+	if err == ErrKeyNotFound {
+		return Block{}, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	if err != nil {
+		return Block{}, err
+	}
+
+	return chain.GetBlock(hash)
+}
+
+// hasIndex check if the indexes have been built, the chains created before
+// the indexes existed don't have them.
+func (chain *BlockChain) hasIndex() (bool, error) {
+	_, err := chain.Database.Get(heightKey(0))
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// ReindexBlocks rebuild the indexes of the height and of the transactions
+// from the blocks of the main chain, and the address index if it is enabled.
+func (chain *BlockChain) ReindexBlocks() error {
+	for _, prefix := range [][]byte{heightPrefix, txPrefix} {
+		if err := deleteByPrefix(chain.Database, prefix); err != nil {
+			return err
+		}
+	}
+
+	//the store can't be read inside BulkWrite, so first we read the blocks
+	var blocks []*Block
+	iter := chain.Iterator()
+	for len(iter.CurrentHash) > 0 {
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+	}
+
+	err := chain.Database.BulkWrite(func(w Writer) error {
+		for _, block := range blocks {
+			if err := indexBlock(w, block); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	//the address index uses the transaction index, it is rebuilt after it
+	enabled, err := chain.HasAddressIndex()
+	if err != nil || !enabled {
+		return err
+	}
+
+	return chain.ReindexAddresses()
+}
@@ -0,0 +1,265 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Mempool keep the valid transactions that are waiting to be mined.
+type Mempool struct {
+	UTXOSet *UTXOSet
+
+	entries map[string]*mempoolEntry //the key is the ID of the transaction
+	spent   map[string]string        //outputs spent by the pool ("txid:index") and the ID of the transaction that spend them
+	mu      sync.Mutex
+}
+
+// mempoolEntry is a transaction of the pool with his fee and his size.
+type mempoolEntry struct {
+	Tx   *Transaction
+	Fee  int
+	Size int
+}
+
+// NewMempool create an empty pool that check the transactions against the UTXOSet.
+func NewMempool(UTXOSet *UTXOSet) *Mempool {
+	return &Mempool{
+		UTXOSet: UTXOSet,
+		entries: make(map[string]*mempoolEntry),
+		spent:   make(map[string]string),
+	}
+}
+
+// outpoint is the key of an output used by the pool
+func outpoint(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
+
+// Add verify the transaction and put it into the pool. The inputs of the
+// transaction must be unspent in the UTXOSet and not spent by another
+// transaction of the pool, and the transaction must be valid inside the next
+// block, also with its absolute and relative locks.
+func (mp *Mempool) Add(tx *Transaction) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return mp.add(tx)
+}
+
+// add is Add with the lock already taken.
+func (mp *Mempool) add(tx *Transaction) error {
+	txID := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[txID]; ok {
+		return errors.New("Transaction is already in the mempool")
+	}
+	if tx.IsCoinbase() {
+		return errors.New("Coinbase transaction can't be in the mempool")
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return errors.New("Transaction ID is not the hash of the transaction")
+	}
+	if tx.Version > TxVersion {
+		return fmt.Errorf("Transaction version %d is not known", tx.Version)
+	}
+	if tx.Version < ScriptTxVersion {
+		return fmt.Errorf("Transaction version %d is too old", tx.Version)
+	}
+	height, err := mp.UTXOSet.Blockchain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if !tx.IsFinal(height+1, time.Now().Unix()) {
+		return fmt.Errorf("Transaction is locked until %d", tx.LockTime)
+	}
+
+	for _, in := range tx.Inputs {
+		if other, ok := mp.spent[outpoint(in.ID, in.Out)]; ok {
+			return fmt.Errorf("Input %x:%d is already spent by %s", in.ID, in.Out, other)
+		}
+	}
+	//the outputs can't be negative and the sums can't overflow
+	fee, err := mp.UTXOSet.TransactionFee(tx)
+	if err != nil {
+		return err
+	}
+	if fee < 0 {
+		return errors.New("Outputs are greater than the inputs")
+	}
+	if err := mp.UTXOSet.Blockchain.CheckSequenceLocks(tx, height+1, time.Now().Unix()); err != nil {
+		return err
+	}
+
+	if err := mp.UTXOSet.Blockchain.VerifyTransaction(tx); err != nil {
+		return err
+	}
+
+	mp.entries[txID] = &mempoolEntry{Tx: tx, Fee: fee, Size: len(tx.Serialize())}
+	for _, in := range tx.Inputs {
+		mp.spent[outpoint(in.ID, in.Out)] = txID
+	}
+
+	return nil
+}
+
+// Get return the transaction of the pool with ID txID.
+func (mp *Mempool) Get(txID []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	entry, ok := mp.entries[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+
+	return entry.Tx, true
+}
+
+// Count return the number of transactions inside the pool.
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	return len(mp.entries)
+}
+
+// Remove delete the transaction with ID txID from the pool.
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	mp.remove(hex.EncodeToString(txID))
+}
+
+func (mp *Mempool) remove(txID string) {
+	entry, ok := mp.entries[txID]
+	if !ok {
+		return
+	}
+
+	for _, in := range entry.Tx.Inputs {
+		delete(mp.spent, outpoint(in.ID, in.Out))
+	}
+	delete(mp.entries, txID)
+}
+
+// Reorganize update the pool after the chain has moved to another branch: the
+// transactions of the disconnected blocks go back into the pool and all the
+// transactions are checked again against the new chain, the ones that are not
+// valid anymore (e.g. they are inside the connected blocks) are removed. The
+// transactions that spend the outputs of other disconnected transactions are
+// lost, the pool has only transactions that spend the UTXOSet.
+func (mp *Mempool) Reorganize(disconnected []*Block) {
+	//the lock is kept until the end, so nobody sees the pool half rebuilt
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	txs := make([]*Transaction, 0, len(mp.entries))
+	for _, entry := range mp.sortedEntries() {
+		txs = append(txs, entry.Tx)
+	}
+	mp.entries = make(map[string]*mempoolEntry)
+	mp.spent = make(map[string]string)
+
+	//the blocks are from the last to the first
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if !tx.IsCoinbase() {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	for _, tx := range txs {
+		if err := mp.add(tx); err != nil {
+			fmt.Printf("Transaction %x removed from the mempool: %s\n", tx.ID, err)
+		}
+	}
+}
+
+// RemoveBlock delete from the pool the transactions of a block that has been
+// added to the chain and the transactions that spend the same outputs.
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID))
+
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if other, ok := mp.spent[outpoint(in.ID, in.Out)]; ok {
+				mp.remove(other)
+			}
+		}
+	}
+}
+
+// sortedEntries return the entries of the pool from the highest fee rate to the lowest.
+func (mp *Mempool) sortedEntries() []*mempoolEntry {
+	var entries []*mempoolEntry
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if cmp := compareFeeRate(a, b); cmp != 0 {
+			return cmp > 0
+		}
+		return hex.EncodeToString(a.Tx.ID) < hex.EncodeToString(b.Tx.ID)
+	})
+
+	return entries
+}
+
+// compareFeeRate compare a.Fee/a.Size with b.Fee/b.Size without division, it
+// return -1, 0 or +1 like big.Int.Cmp. The products are big.Int because they
+// can overflow an int.
+func compareFeeRate(a, b *mempoolEntry) int {
+	x := new(big.Int).Mul(big.NewInt(int64(a.Fee)), big.NewInt(int64(b.Size)))
+	y := new(big.Int).Mul(big.NewInt(int64(b.Fee)), big.NewInt(int64(a.Size)))
+
+	return x.Cmp(y)
+}
+
+// Transactions return all the transactions of the pool ordered by fee rate.
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*Transaction
+	for _, entry := range mp.sortedEntries() {
+		txs = append(txs, entry.Tx)
+	}
+
+	return txs
+}
+
+// BuildBlockTemplate choose the transactions with the highest fee rate whose
+// total size is not greater than maxSize bytes, they are the transactions of
+// the next block that the miner will mine.
+func (mp *Mempool) BuildBlockTemplate(maxSize int) []*Transaction {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	var txs []*Transaction
+	size := 0
+	for _, entry := range mp.sortedEntries() {
+		if size+entry.Size > maxSize {
+			continue
+		}
+
+		txs = append(txs, entry.Tx)
+		size += entry.Size
+	}
+
+	return txs
+}
@@ -0,0 +1,19 @@
+package blockchain
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSortedEntriesLargeFees(t *testing.T) {
+	//the products of the fees and the sizes overflow an int
+	low := &mempoolEntry{Tx: &Transaction{ID: []byte{1}}, Fee: math.MaxInt / 2, Size: 1000}
+	high := &mempoolEntry{Tx: &Transaction{ID: []byte{2}}, Fee: math.MaxInt / 3, Size: 100}
+	mp := &Mempool{entries: map[string]*mempoolEntry{"01": low, "02": high}}
+
+	entries := mp.sortedEntries()
+	if entries[0] != high || entries[1] != low {
+		t.Errorf("entries are ordered %d/%d, %d/%d, want the highest fee rate first",
+			entries[0].Fee, entries[0].Size, entries[1].Fee, entries[1].Size)
+	}
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// MerkleTree is a binary tree of hashes, the leaves are the hashes of the
+// transactions and every other node is the hash of his two children.
+type MerkleTree struct {
+	RootNode *MerkleNode
+}
+
+// MerkleNode is a node of the MerkleTree
+type MerkleNode struct {
+	Left  *MerkleNode
+	Right *MerkleNode
+	Data  []byte //the hash of the node
+}
+
+// NewMerkleNode create a node, if left and right are nil the node is a leaf
+// and data is hashed, otherwise the node is the hash of his children.
+func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
+	node := MerkleNode{}
+
+	if left == nil && right == nil {
+		hash := sha256.Sum256(data)
+		node.Data = hash[:]
+	} else {
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
+		hash := sha256.Sum256(prevHashes)
+		node.Data = hash[:]
+	}
+
+	node.Left = left
+	node.Right = right
+
+	return &node
+}
+
+// NewMerkleTree build the tree from the data of the leaves. When a level has
+// an odd number of nodes the last node is duplicated.
+func NewMerkleTree(data [][]byte) *MerkleTree {
+	var nodes []*MerkleNode
+
+	for _, dat := range data {
+		nodes = append(nodes, NewMerkleNode(nil, nil, dat))
+	}
+
+	if len(nodes) == 0 {
+		nodes = append(nodes, NewMerkleNode(nil, nil, []byte{}))
+	}
+
+	for len(nodes) > 1 {
+		if len(nodes)%2 != 0 {
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
+		var level []*MerkleNode
+		for i := 0; i < len(nodes); i += 2 {
+			level = append(level, NewMerkleNode(nodes[i], nodes[i+1], nil))
+		}
+
+		nodes = level
+	}
+
+	return &MerkleTree{RootNode: nodes[0]}
+}
+
+// MerkleProof return the hashes needed to go from the transaction txID to the
+// merkle root of the block (from the bottom to the top) and the position of
+// the transaction inside the block.
+func (b *Block) MerkleProof(txID []byte) ([][]byte, int, error) {
+	var proof [][]byte
+	var level [][]byte
+	index := -1
+
+	for i, tx := range b.Transactions {
+		if bytes.Equal(tx.ID, txID) {
+			index = i
+		}
+		leaf := NewMerkleNode(nil, nil, tx.ID)
+		level = append(level, leaf.Data)
+	}
+
+	if index == -1 {
+		return nil, 0, errors.New("Transaction is not inside the block")
+	}
+
+	position := index
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+
+		//the sibling is on the right if we are on the left and vice versa
+		proof = append(proof, level[position^1])
+
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			hash := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, hash[:])
+		}
+
+		level = next
+		position /= 2
+	}
+
+	return proof, index, nil
+}
+
+// VerifyMerkleProof check that the transaction txID at position index is
+// inside the block with merkle root root, using the proof of Block.MerkleProof.
+// The leaves and the other nodes are hashed in the same way, so txID and the
+// hashes of the proof must be 32 bytes: the 64 bytes of two children can't be
+// used as a transaction ID. The proof must have a hash for every bit of index.
+func VerifyMerkleProof(root, txID []byte, index int, proof [][]byte) bool {
+	if len(txID) != sha256.Size || index < 0 || index>>uint(len(proof)) != 0 {
+		return false
+	}
+	leaf := sha256.Sum256(txID)
+	hash := leaf[:]
+
+	for _, sibling := range proof {
+		if len(sibling) != sha256.Size {
+			return false
+		}
+
+		var data []byte
+		if index%2 == 0 {
+			data = append(append(data, hash...), sibling...)
+		} else {
+			data = append(append(data, sibling...), hash...)
+		}
+
+		next := sha256.Sum256(data)
+		hash = next[:]
+		index /= 2
+	}
+
+	return bytes.Equal(hash, root)
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+// testBlock return a block with n transactions that have only an ID, the ID
+// of the transaction i is 32 bytes of i+1.
+func testBlock(n int) *Block {
+	block := &Block{BlockHeader: BlockHeader{Version: BlockVersion}}
+	for i := 0; i < n; i++ {
+		block.Transactions = append(block.Transactions, &Transaction{ID: bytes.Repeat([]byte{byte(i + 1)}, 32)})
+	}
+
+	return block
+}
+
+// sum256 return the sha256 of the data joined together.
+func sum256(data ...[]byte) []byte {
+	h := sha256.Sum256(bytes.Join(data, nil))
+	return h[:]
+}
+
+func TestMerkleRoot(t *testing.T) {
+	ids := testBlock(3).Transactions
+	a, b, c := sum256(ids[0].ID), sum256(ids[1].ID), sum256(ids[2].ID)
+
+	//the last node of a level with an odd number of nodes is duplicated
+	tests := []struct {
+		n    int
+		root []byte
+	}{
+		{0, sum256(nil)},
+		{1, a},
+		{2, sum256(a, b)},
+		{3, sum256(sum256(a, b), sum256(c, c))},
+	}
+	for _, test := range tests {
+		if root := testBlock(test.n).HashTransactions(); !bytes.Equal(root, test.root) {
+			t.Errorf("merkle root of %d transactions = %x, want %x", test.n, root, test.root)
+		}
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		block := testBlock(n)
+		root := block.HashTransactions()
+
+		for i, tx := range block.Transactions {
+			proof, index, err := block.MerkleProof(tx.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if index != i {
+				t.Errorf("%d transactions: index of the transaction %d = %d", n, i, index)
+			}
+			if !VerifyMerkleProof(root, tx.ID, index, proof) {
+				t.Errorf("%d transactions: the proof of the transaction %d is not valid", n, i)
+			}
+		}
+	}
+
+	if _, _, err := testBlock(3).MerkleProof(bytes.Repeat([]byte{9}, 32)); err == nil {
+		t.Error("MerkleProof of a transaction that is not inside the block")
+	}
+}
+
+func TestMerkleProofNotValid(t *testing.T) {
+	block := testBlock(5)
+	root := block.HashTransactions()
+	txID := block.Transactions[2].ID
+	proof, index, err := block.MerkleProof(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//change returns a copy of the proof changed by fn
+	change := func(fn func(proof [][]byte) [][]byte) [][]byte {
+		var proofCopy [][]byte
+		for _, sibling := range proof {
+			proofCopy = append(proofCopy, append([]byte{}, sibling...))
+		}
+		return fn(proofCopy)
+	}
+
+	tests := []struct {
+		name  string
+		root  []byte
+		txID  []byte
+		index int
+		proof [][]byte
+	}{
+		{"tampered sibling", root, txID, index, change(func(p [][]byte) [][]byte { p[1][0] ^= 1; return p })},
+		{"swapped siblings", root, txID, index, change(func(p [][]byte) [][]byte { p[0], p[1] = p[1], p[0]; return p })},
+		{"missing sibling", root, txID, index, proof[:len(proof)-1]},
+		{"sibling of 31 bytes", root, txID, index, change(func(p [][]byte) [][]byte { p[0] = p[0][:31]; return p })},
+		{"sibling of 64 bytes", root, txID, index, change(func(p [][]byte) [][]byte { p[0] = append(p[0], p[0]...); return p })},
+		{"another transaction", root, block.Transactions[3].ID, index, proof},
+		{"another index", root, txID, index ^ 1, proof},
+		{"index out of the proof", root, txID, index + 1<<uint(len(proof)), proof},
+		{"negative index", root, txID, -1, proof},
+		{"transaction ID of 31 bytes", root, txID[:31], index, proof},
+		{"another root", sum256(root), txID, index, proof},
+	}
+	for _, test := range tests {
+		if VerifyMerkleProof(test.root, test.txID, test.index, test.proof) {
+			t.Errorf("%s: the proof is valid", test.name)
+		}
+	}
+}
+
+// TestMerkleProofInnerNode check that the two children of a node can't be
+// used as a transaction ID: they are 64 bytes and their hash is the node.
+func TestMerkleProofInnerNode(t *testing.T) {
+	block := testBlock(4)
+	root := block.HashTransactions()
+
+	left, right := sum256(block.Transactions[0].ID), sum256(block.Transactions[1].ID)
+	sibling := sum256(sum256(block.Transactions[2].ID), sum256(block.Transactions[3].ID))
+
+	//the proof of the node of the first two transactions without its first level
+	fakeID := append(append([]byte{}, left...), right...)
+	if !bytes.Equal(sum256(sum256(fakeID), sibling), root) {
+		t.Fatal("the fake proof doesn't reach the root")
+	}
+	if VerifyMerkleProof(root, fakeID, 0, [][]byte{sibling}) {
+		t.Error("the 64 bytes of two children are accepted as a transaction ID")
+	}
+}
@@ -0,0 +1,387 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	badgerv1 "github.com/dgraph-io/badger"
+)
+
+// IsBadgerV1 return true if the db inside path was created by Badger v1,
+// the version used before the upgrade to v4. Badger v4 can't open it, the
+// keys must be copied with MigrateV1.
+func IsBadgerV1(path string) (bool, error) {
+	f, err := os.Open(filepath.Join(path, "MANIFEST"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	//the MANIFEST starts with "Bdgr" and the version: 4 in v1, 8 (and the external magic) in v4
+	var magic [8]byte
+	if _, err := io.ReadFull(f, magic[:]); err != nil {
+		return false, nil
+	}
+
+	return bytes.Equal(magic[:4], []byte("Bdgr")) && binary.BigEndian.Uint32(magic[4:]) == 4, nil
+}
+
+// MigrateV1 copy all the keys of the Badger v1 db inside from into a new
+// Badger v4 db in the DBPath of opts, and return how many keys have been
+// copied. If from is the DBPath the old db is kept inside DBPath()+".v1",
+// otherwise from isn't changed (e.g. the ./tmp/blocks of the old versions).
+func MigrateV1(from string, opts Options) (int, error) {
+	path := opts.DBPath()
+	old, err := IsBadgerV1(from)
+	if err != nil {
+		return 0, err
+	}
+	if !old {
+		return 0, fmt.Errorf("%s is not a Badger v1 database", from)
+	}
+
+	inPlace := filepath.Clean(from) == filepath.Clean(path)
+	if !inPlace && DBExist(path) {
+		return 0, fmt.Errorf("%w: %s", ErrChainExists, path)
+	}
+
+	backup := path + ".v1"
+	if _, err := os.Stat(backup); err == nil {
+		return 0, fmt.Errorf("%s already exists, remove it before the migration", backup)
+	}
+
+	//the new db is written aside, a failed migration only leaves this directory
+	tmpPath := path + ".migrating"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return 0, err
+	}
+
+	count, err := copyV1(from, tmpPath, opts)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return 0, err
+	}
+
+	if inPlace {
+		if err := os.Rename(path, backup); err != nil {
+			return 0, err
+		}
+	} else if err := os.RemoveAll(path); err != nil { //an empty directory left by a command that found no chain
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// copyV1 copy the keys of the v1 db inside from into a new v4 db inside to.
+func copyV1(from, to string, opts Options) (int, error) {
+	oldOpts := badgerv1.DefaultOptions
+	oldOpts.Dir = from
+	oldOpts.ValueDir = from
+	oldDB, err := badgerv1.Open(oldOpts)
+	if err != nil {
+		return 0, err
+	}
+	defer oldDB.Close()
+
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return 0, err
+	}
+	store, err := NewBadgerStore(badgerOptions(opts, to))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = store.BulkWrite(func(w Writer) error {
+		return oldDB.View(func(txn *badgerv1.Txn) error {
+			it := txn.NewIterator(badgerv1.DefaultIteratorOptions)
+			defer it.Close()
+
+			for it.Rewind(); it.Valid(); it.Next() {
+				item := it.Item()
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				if err := w.Put(item.KeyCopy(nil), value); err != nil {
+					return err
+				}
+				count++
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		store.Close()
+		return 0, err
+	}
+
+	return count, store.Close()
+}
+
+// dbVersion return the DBVersion of the db, 0 if the chain was saved with gob.
+func dbVersion(db Store) (int, error) {
+	v, err := db.Get(encodingKey)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 1 {
+		return 0, fmt.Errorf("Database version %x is not valid", v)
+	}
+
+	return int(v[0]), nil
+}
+
+// MigrateEncoding convert the chain of opts saved by the old versions to the
+// DBVersion of this version, and return how many values have been converted.
+// The values saved with gob are converted into the binary format: the
+// transactions keep their ID and their signatures with LegacyTxVersion. The
+// blocks saved in one value are split into header and body, their headers
+// keep LegacyBlockVersion and their Height is calculated again from the
+// genesis, the first version didn't save it. The headers saved without the Seal get an empty
+// one. The UTXO set and the undo data are rebuilt from the blocks, so their
+// outputs have the scripts. It does nothing if the chain is already
+// converted, and it can run again if it fails.
+func MigrateEncoding(opts Options) (int, error) {
+	if opts.Store == nil && !DBExist(opts.DBPath()) {
+		return 0, ErrChainNotFound
+	}
+	db, err := openStore(opts)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Store == nil {
+		defer db.Close()
+	}
+
+	version, err := dbVersion(db)
+	if err != nil || version == DBVersion {
+		return 0, err
+	}
+	if version > DBVersion {
+		return 0, fmt.Errorf("Database version %d is newer than this program", version)
+	}
+
+	//the store can't be changed while we iterate, so first we convert all the values
+	var blocks []*Block
+	var oldKeys [][]byte
+	headers := make(map[string][]byte)
+	err = db.Iterate(nil, func(key, value []byte) error {
+		switch {
+		case len(key) == 32: //the old versions saved the whole block with his hash as key
+			block, err := deserializeBlockV1(value)
+			if err != nil && version == 0 {
+				block, err = DeserializeGobBlock(value)
+			}
+			if err != nil {
+				return fmt.Errorf("Block %x: %w", key, err)
+			}
+			blocks = append(blocks, block)
+			oldKeys = append(oldKeys, append([]byte{}, key...))
+		case bytes.HasPrefix(key, headerPrefix):
+			hash := key[len(headerPrefix):]
+			if _, err := deserializeHeader(hash, value); err == nil {
+				return nil //already with the Seal
+			}
+			block, err := deserializeHeaderV2(hash, value)
+			if err != nil {
+				return fmt.Errorf("Header %x: %w", hash, err)
+			}
+			headers[string(key)] = headerValue(block)
+		default: //the UTXO set and the undo data are rebuilt, the other keys (lh, work, indexes) don't have transactions
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := setHeights(db, blocks); err != nil {
+		return 0, err
+	}
+
+	//the old key of a block is removed after his header and body are saved
+	err = db.BulkWrite(func(w Writer) error {
+		for i, block := range blocks {
+			if err := putBlock(w, block); err != nil {
+				return err
+			}
+			if err := w.Delete(oldKeys[i]); err != nil {
+				return err
+			}
+		}
+		for key, value := range headers {
+			if err := w.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	//the outputs of the UTXO set and of the undo data didn't have the scripts
+	lastHash, err := db.Get([]byte("lh"))
+	if err != nil {
+		return 0, err
+	}
+	chain := &BlockChain{LastHash: lastHash, Database: db}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		return 0, err
+	}
+	undos, err := rebuildUndo(chain)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(blocks) + undos + len(headers), db.Put(encodingKey, []byte{DBVersion})
+}
+
+// setHeights set the Height of the blocks from the height of their previous
+// block, that is one of the blocks or a block already saved inside db.
+func setHeights(db Store, blocks []*Block) error {
+	byHash := make(map[string]*Block)
+	for _, block := range blocks {
+		byHash[string(block.Hash)] = block
+	}
+
+	done := make(map[string]bool)
+	var setHeight func(block *Block) error
+	setHeight = func(block *Block) error {
+		if done[string(block.Hash)] {
+			return nil
+		}
+
+		if len(block.PrevHash) == 0 {
+			block.Height = 0
+		} else if prev, ok := byHash[string(block.PrevHash)]; ok {
+			if err := setHeight(prev); err != nil {
+				return err
+			}
+			block.Height = prev.Height + 1
+		} else {
+			prev, err := getHeader(db, block.PrevHash)
+			if err != nil {
+				return fmt.Errorf("Block %x: %w", block.Hash, err)
+			}
+			block.Height = prev.Height + 1
+		}
+
+		done[string(block.Hash)] = true
+		return nil
+	}
+
+	for _, block := range blocks {
+		if err := setHeight(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebuildUndo write again the undo data of the blocks of the main chain with
+// the outputs that they spend, and return how many blocks have been written.
+// The blocks without undo data are skipped.
+func rebuildUndo(chain *BlockChain) (int, error) {
+	hasUndo := make(map[string]bool)
+	err := chain.Database.Iterate(undoPrefix, func(key, value []byte) error {
+		hasUndo[string(key[len(undoPrefix):])] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var blocks []*Block
+	iter := chain.Iterator()
+	for len(iter.CurrentHash) > 0 {
+		block, err := iter.Next()
+		if err != nil {
+			return 0, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	//the blocks are read from the genesis, like UTXOSet.Update does
+	outputs := make(map[string][]TxOutput)
+	undos := make(map[string][]byte)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		undo := BlockUndo{}
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Inputs {
+					outs := outputs[string(in.ID)]
+					if in.Out < 0 || in.Out >= len(outs) {
+						return 0, fmt.Errorf("Output %x:%d spent by block %x doesn't exist", in.ID, in.Out, block.Hash)
+					}
+					undo.Spent = append(undo.Spent, SpentOutput{in.ID, in.Out, outs[in.Out]})
+				}
+			}
+			outputs[string(tx.ID)] = tx.Outputs
+		}
+		if hasUndo[string(block.Hash)] {
+			undos[string(append(undoPrefix, block.Hash...))] = undo.Serialize()
+		}
+	}
+
+	err = chain.Database.BulkWrite(func(w Writer) error {
+		for key, value := range undos {
+			if err := w.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return len(undos), err
+}
+
+// deserializeHeaderV2 decode a header saved by the chains with DBVersion 2,
+// when the headers didn't have the Seal.
+func deserializeHeaderV2(blockHash, data []byte) (*Block, error) {
+	d := newDecoder(data)
+	block := &Block{BlockHeader: d.header(), Hash: append([]byte{}, blockHash...), Height: d.int()}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// deserializeBlockV1 decode a block saved in one value by the chains with
+// DBVersion 1, when the headers didn't have a version.
+func deserializeBlockV1(data []byte) (*Block, error) {
+	d := newDecoder(data)
+	block := &Block{Hash: d.bytes()}
+	block.PrevHash = d.bytes()
+	block.MerkleRoot = d.bytes()
+	block.Timestamp = d.varint()
+	block.Bits = d.uint32("bits")
+	block.Nonce = d.int()
+	block.Height = d.int()
+	block.Transactions = d.transactions()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// copyDir copy the files of the db src into dst.
+func copyDir(t *testing.T, src, dst string) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMigrateBaselineChain(t *testing.T) {
+	//./tmp/blocks was saved by the first version with Badger v1 and gob, the
+	//copy keeps it unchanged
+	from := filepath.Join(t.TempDir(), "blocks")
+	copyDir(t, filepath.Join("..", "tmp", "blocks"), from)
+
+	opts := DefaultOptions("test")
+	opts.DataDir = t.TempDir()
+	if _, err := MigrateV1(from, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateEncoding(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	chain, err := ContinueBlockChain(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Database.Close()
+
+	var headers []*Block
+	iter := chain.Iterator()
+	for len(iter.CurrentHash) > 0 {
+		block, err := iter.NextHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, block)
+	}
+	if len(headers) == 0 {
+		t.Fatal("the migrated chain has no blocks")
+	}
+	for i, block := range headers {
+		if want := len(headers) - 1 - i; block.Height != want {
+			t.Errorf("block %x has height %d, want %d", block.Hash, block.Height, want)
+		}
+		if block.Bits != BigToCompact(powLimit) {
+			t.Errorf("block %x has bits %08x, want %08x", block.Hash, block.Bits, BigToCompact(powLimit))
+		}
+		if err := chain.CheckSeal(block); err != nil {
+			t.Errorf("block %x: %s", block.Hash, err)
+		}
+	}
+
+	//the migrated chain can be extended
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	miner, err := ws.AddWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := chain.AddBlock(miner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Height != len(headers) {
+		t.Errorf("new block has height %d, want %d", block.Height, len(headers))
+	}
+	if got := balance(t, chain, miner); got != BlockSubsidy(block.Height) {
+		t.Errorf("balance of the miner is %d, want %d", got, BlockSubsidy(block.Height))
+	}
+}
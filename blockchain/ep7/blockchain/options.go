@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const DefaultDataDir = "./tmp" //where the chains and the wallets are saved if no directory is given
+
+// Options say where a BlockChain and its wallets are saved, so a process can
+// open more chains side by side using a different DataDir or Network.
+type Options struct {
+	DataDir    string         //directory of the chains and the wallets
+	Network    string         //name of the chain inside DataDir, the CLI uses the NODE_ID
+	WalletPath string         //file of the wallets, if it is empty the file is inside DataDir
+	Badger     badger.Options //options of the db, Dir and ValueDir are set by DBPath
+	Store      Store          //if it is not nil the chain is saved here instead of the Badger db (e.g. a MemoryStore)
+	Consensus  Consensus      //consensus of a new chain, PoW if it is nil; the chains save their consensus
+}
+
+// DefaultOptions return the options of the chain network inside DefaultDataDir.
+func DefaultOptions(network string) Options {
+	return Options{
+		DataDir: DefaultDataDir,
+		Network: network,
+		Badger:  badger.DefaultOptions("").WithLoggingLevel(badger.WARNING),
+	}
+}
+
+// DBPath return the directory of the db of the chain.
+func (opts Options) DBPath() string {
+	return filepath.Join(opts.DataDir, "blocks_"+opts.Network)
+}
+
+// WalletFile return the file where the wallets of the chain are saved.
+func (opts Options) WalletFile() string {
+	if opts.WalletPath != "" {
+		return opts.WalletPath
+	}
+
+	return filepath.Join(opts.DataDir, "wallets_"+opts.Network+".data")
+}
+
+// openStore return the Store of the chain: opts.Store if it is set, otherwise
+// the Badger db inside DBPath, the directory is created if it doesn't exist.
+func openStore(opts Options) (Store, error) {
+	if opts.Store != nil {
+		return opts.Store, nil
+	}
+
+	path := opts.DBPath()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	//v4 can't read the files of v1, the db must be migrated first
+	old, err := IsBadgerV1(path)
+	if err != nil {
+		return nil, err
+	}
+	if old {
+		return nil, fmt.Errorf("%w: %s", ErrOldDatabase, path)
+	}
+
+	store, err := NewBadgerStore(badgerOptions(opts, path))
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// badgerOptions return the Badger options of opts for the db inside path.
+func badgerOptions(opts Options, path string) badger.Options {
+	//a zero Badger is not valid, the user didn't set it
+	badgerOpts := opts.Badger
+	if badgerOpts.MemTableSize == 0 {
+		badgerOpts = DefaultOptions(opts.Network).Badger
+	}
+	badgerOpts.Dir = path      // where the db store the keys and metadata
+	badgerOpts.ValueDir = path //where the db will store all the values
+
+	return badgerOpts
+}
@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// PartialTransaction is a transaction that is not signed by all its owners
+// yet, with the transactions of the outputs that it spends. It can be saved
+// into a file and signed by more wallet files one after the other, e.g. by
+// the owners of a multisig address or by a wallet on an offline machine,
+// without reading the chain. The signatures don't cover the values of the
+// spent outputs, so the file has the whole transactions: their hash must be
+// the ID referenced by the inputs, so the signer knows the real values.
+type PartialTransaction struct {
+	Tx      *Transaction
+	PrevTXs []*Transaction //the transactions of the outputs spent by Tx
+}
+
+// NewPartialTransaction return the PartialTransaction of tx with the
+// transactions of the chain that it spends.
+func (chain *BlockChain) NewPartialTransaction(tx *Transaction) (*PartialTransaction, error) {
+	p := &PartialTransaction{Tx: tx}
+	found := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		if found[hex.EncodeToString(in.ID)] {
+			continue
+		}
+		prevTX, err := chain.FindTransaction(in.ID)
+		if err != nil {
+			return nil, err
+		}
+		found[hex.EncodeToString(in.ID)] = true
+		p.PrevTXs = append(p.PrevTXs, &prevTX)
+	}
+
+	return p, nil
+}
+
+// prevTXs return the map of the previous transactions used by Sign and Verify,
+// the ID of every transaction must be its hash so the outputs are the real ones.
+func (p *PartialTransaction) prevTXs() (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	for _, prevTX := range p.PrevTXs {
+		if !bytes.Equal(prevTX.ID, prevTX.Hash()) {
+			return nil, fmt.Errorf("Previous transaction %x is not valid", prevTX.ID)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = *prevTX
+	}
+
+	return prevTXs, nil
+}
+
+// PrevOutputs return the outputs spent by the inputs of the transaction.
+func (p *PartialTransaction) PrevOutputs() ([]TxOutput, error) {
+	prevTXs, err := p.prevTXs()
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Tx.prevOutputs(prevTXs)
+}
+
+// Fee return the value of the spent outputs that is not spent by the outputs.
+func (p *PartialTransaction) Fee() (int, error) {
+	prevOutputs, err := p.PrevOutputs()
+	if err != nil {
+		return 0, err
+	}
+
+	fee := 0
+	for _, out := range prevOutputs {
+		fee += out.Value
+	}
+	for _, out := range p.Tx.Outputs {
+		fee -= out.Value
+	}
+	return fee, nil
+}
+
+// Sign add the signatures of the wallets, see Transaction.Sign.
+func (p *PartialTransaction) Sign(ws *wallet.Wallets) error {
+	prevTXs, err := p.prevTXs()
+	if err != nil {
+		return err
+	}
+
+	return p.Tx.Sign(ws, prevTXs)
+}
+
+// Verify check that the transaction has all the signatures that it needs.
+func (p *PartialTransaction) Verify() error {
+	prevTXs, err := p.prevTXs()
+	if err != nil {
+		return err
+	}
+
+	return p.Tx.Verify(prevTXs)
+}
+
+// Serialize encode the PartialTransaction with the binary format of encoding.go.
+func (p *PartialTransaction) Serialize() []byte {
+	e := newEncoder()
+	e.partial(p)
+
+	return e.buf.Bytes()
+}
+
+// DeserializePartialTransaction decode the data of a PartialTransaction.
+func DeserializePartialTransaction(data []byte) (*PartialTransaction, error) {
+	d := newDecoder(data)
+	p := d.partial()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
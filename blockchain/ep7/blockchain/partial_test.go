@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// roundTrip save the partial transaction and read it again, like the file of createrawtx.
+func roundTrip(t *testing.T, p *PartialTransaction) *PartialTransaction {
+	data := p.Serialize()
+	read, err := DeserializePartialTransaction(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read.Serialize(), data) {
+		t.Fatal("the partial transaction changed after Serialize and DeserializePartialTransaction")
+	}
+
+	return read
+}
+
+func TestPartialTransaction(t *testing.T) {
+	wallets := makeWallets(t, 3)
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+	redeemScript, err := MultisigScript(2, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, prevTXs := spendTx(P2SHScript(wallet.PublicKeyHash(redeemScript)))
+	p := &PartialTransaction{Tx: tx}
+	for _, prevTX := range prevTXs {
+		prevTX := prevTX
+		p.PrevTXs = append(p.PrevTXs, &prevTX)
+	}
+	if fee, err := p.Fee(); err != nil || fee != 1 {
+		t.Fatalf("Fee = %d, %v, want 1", fee, err)
+	}
+
+	//every owner reads the file, signs it and saves it again
+	p = roundTrip(t, p)
+	if err := p.Verify(); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("Verify without signatures = %v, want ErrScriptFailed", err)
+	}
+	if err := p.Sign(walletsOf(wallets[2:3], redeemScript)); err != nil {
+		t.Fatal(err)
+	}
+	p = roundTrip(t, p)
+	if err := p.Verify(); !errors.Is(err, ErrScriptFailed) {
+		t.Fatalf("Verify with one signature = %v, want ErrScriptFailed", err)
+	}
+
+	//the second owner doesn't have the script, it is inside the ScriptSig
+	if err := p.Sign(walletsOf(wallets[0:1])); err != nil {
+		t.Fatal(err)
+	}
+	p = roundTrip(t, p)
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify with two signatures = %v", err)
+	}
+	if !bytes.Equal(p.Tx.ID, tx.ID) {
+		t.Errorf("the signatures changed the ID %x into %x", tx.ID, p.Tx.ID)
+	}
+
+	//the ScriptSig keeps only the signatures that are needed
+	if err := p.Sign(walletsOf(wallets[1:2])); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify with three signatures = %v", err)
+	}
+	if ins, _ := parseScript(p.Tx.Inputs[0].ScriptSig); len(ins) != 3 {
+		t.Errorf("ScriptSig %s doesn't have two signatures", ScriptString(p.Tx.Inputs[0].ScriptSig))
+	}
+}
+
+func TestPartialTransactionNotValid(t *testing.T) {
+	w := makeWallets(t, 1)[0]
+	tx, prevTXs := spendTx(P2PKHScript(wallet.PublicKeyHash(w.PublicKey)))
+	p := &PartialTransaction{Tx: tx}
+	for _, prevTX := range prevTXs {
+		prevTX := prevTX
+		p.PrevTXs = append(p.PrevTXs, &prevTX)
+	}
+	data := p.Serialize()
+
+	//the file says that the spent output has a bigger value
+	p.PrevTXs[0].Outputs[0].Value = 1000
+	if err := p.Sign(walletsOf([]*wallet.Wallet{w})); err == nil {
+		t.Error("Sign accepted a previous transaction that doesn't match its ID")
+	}
+	if _, err := p.Fee(); err == nil {
+		t.Error("Fee accepted a previous transaction that doesn't match its ID")
+	}
+
+	//a file without the previous transactions
+	if err := (&PartialTransaction{Tx: tx}).Sign(walletsOf([]*wallet.Wallet{w})); !errors.Is(err, ErrTxNotFound) {
+		t.Errorf("Sign without the previous transactions = %v, want ErrTxNotFound", err)
+	}
+
+	for _, bad := range [][]byte{data[:len(data)-1], append(append([]byte{}, data...), 0), nil} {
+		if _, err := DeserializePartialTransaction(bad); err == nil {
+			t.Errorf("DeserializePartialTransaction accepted %d bytes of a file of %d", len(bad), len(data))
+		}
+	}
+}
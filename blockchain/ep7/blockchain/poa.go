@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+const signatureLength = 64 //bytes of the signatures made by wallet.Sign
+
+// errNoAuthorities is returned by a PoA without authorities, no block can be signed.
+var errNoAuthorities = errors.New("Proof of authority needs at least one authority")
+
+// PoA is the proof of authority: a set of authorities sign the blocks in
+// turn, the block at height h is signed by Authorities[h % len(Authorities)].
+// The Seal of a block is the signature of his Hash followed by the public
+// key of the authority. Every block has the same work, so the node follows
+// the longest chain.
+type PoA struct {
+	Authorities [][]byte //public key hashes of the authorities
+	WalletFile  string   //wallets of this node, Seal uses the key of the authority in turn
+}
+
+// NewPoA return the PoA of the authorities, the addresses of their wallets.
+func NewPoA(authorities []string, walletFile string) (*PoA, error) {
+	if len(authorities) == 0 {
+		return nil, errNoAuthorities
+	}
+
+	poa := &PoA{WalletFile: walletFile}
+	for _, address := range authorities {
+		pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+		if err != nil {
+			return nil, err
+		}
+		poa.Authorities = append(poa.Authorities, pubKeyHash)
+	}
+
+	return poa, nil
+}
+
+// Name return "PoA".
+func (p *PoA) Name() string {
+	return "PoA"
+}
+
+// authority return the public key hash of the authority that signs the block
+// at height, it fails if the PoA has no authorities.
+func (p *PoA) authority(height int) ([]byte, error) {
+	if len(p.Authorities) == 0 {
+		return nil, errNoAuthorities
+	}
+
+	return p.Authorities[height%len(p.Authorities)], nil
+}
+
+// Prepare doesn't change the block, the PoA doesn't use the Bits.
+func (p *PoA) Prepare(chain *BlockChain, block *Block) error {
+	return nil
+}
+
+// Seal sign the block with the key of the authority in turn, it fails with
+// wallet.ErrUnknownWallet if the key is not inside the wallets of this node.
+func (p *PoA) Seal(ctx context.Context, block *Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	authority, err := p.authority(block.Height)
+	if err != nil {
+		return err
+	}
+	wallets, err := wallet.CreateWallets(p.WalletFile)
+	if err != nil {
+		return err
+	}
+	var signer *wallet.Wallet
+	for _, w := range wallets.Wallets {
+		if bytes.Equal(wallet.PublicKeyHash(w.PublicKey), authority) {
+			signer = w
+			break
+		}
+	}
+	if signer == nil {
+		return fmt.Errorf("%w: the authority of block %d is %x", wallet.ErrUnknownWallet, block.Height, authority)
+	}
+
+	block.Nonce = 0
+	block.Hash = block.BlockHash()
+	signature, err := wallet.Sign(&signer.PrivateKey, block.Hash)
+	if err != nil {
+		return err
+	}
+	block.Seal = append(signature, signer.PublicKey...)
+
+	return nil
+}
+
+// Verify check that the block is signed by the authority in turn.
+func (p *PoA) Verify(chain *BlockChain, block *Block) error {
+	if len(block.Seal) <= signatureLength {
+		return invalidBlock("Block is not signed by an authority")
+	}
+	signature, pubKey := block.Seal[:signatureLength], block.Seal[signatureLength:]
+
+	authority, err := p.authority(block.Height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(pubKey), authority) {
+		return invalidBlock("Block %d must be signed by the authority %x", block.Height, authority)
+	}
+	if !wallet.Verify(pubKey, block.Hash, signature) {
+		return invalidBlock("Block has an invalid signature of the authority")
+	}
+
+	return nil
+}
+
+// Work return 1, every block has the same work.
+func (p *PoA) Work(block *Block) *big.Int {
+	return big.NewInt(1)
+}
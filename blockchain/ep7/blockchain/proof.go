@@ -1,13 +1,14 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
-	"log"
 	"math"
 	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 //	TO DO
@@ -18,81 +19,192 @@ import (
 //Requirements:
 //The first few bytes must contans 0s
 
-// Difficulty in this example is costant, but in real blockchain you need to
-//implemenet an algorithm that would slowly increment this difficulty
-//over a large period of time
-const Difficulty = 12
+// The difficulty is not costant: every block store his target in Bits (like the
+//nBits of bitcoin) and every RetargetInterval blocks the target is adjusted so
+//that the blocks are mined every TargetBlockTime seconds.
+const (
+	InitialDifficulty = 12 //number of zero bits of the first target, it is also the easiest difficulty
+	RetargetInterval  = 10 //number of blocks between two adjustments of the difficulty
+	TargetBlockTime   = 10 //seconds that we want between two blocks
+)
+
+// MaxNonce is the biggest nonce tried by Run, when all the nonces have been
+// tried the miner changes the extra nonce of the coinbase (see MineBlock).
+const MaxNonce = math.MaxInt32
+
+// powLimit is the biggest target (the easiest difficulty) that a block can have
+var powLimit = new(big.Int).Lsh(big.NewInt(1), uint(256-InitialDifficulty))
 
 // ProofOfWork is the struct
 type ProofOfWork struct {
-	Block  *Block   //Block is the block inside the blockchain
-	Target *big.Int //Target is a number that rappresents the requirement wich dirived from Difficulty
+	Header *BlockHeader //Header is the header of the block, the transactions are hashed by the MerkleRoot
+	Target *big.Int     //Target is a number that rappresents the requirement wich dirived from the Bits of the header
+	Hashes uint64       //Hashes is the number of hashes tried by Run
 }
 
-//NewProof Initialize a new ProofOfWork, by taking the data from the block (1)
-func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	//256 is the number of bytes inside one of our hashes
-	//left shift the target of 256bytes-Difficulty
-	target.Lsh(target, uint(256-Difficulty))
-	pow := &ProofOfWork{Block: b, Target: target}
+//NewProof Initialize a new ProofOfWork, by taking the data from the header of the block (1)
+func NewProof(h *BlockHeader) *ProofOfWork {
+	target := CompactToBig(h.Bits)
+	pow := &ProofOfWork{Header: h, Target: target}
 	return pow
 }
 
+// CompactToBig convert the compact rappresentation of a target into a big.Int.
+// The first byte is the exponent (the length of the number in bytes) and the
+// other 3 bytes are the most significant bytes of the number.
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	exponent := uint(compact >> 24)
+
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		return big.NewInt(int64(mantissa))
+	}
+
+	target := big.NewInt(int64(mantissa))
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact convert a target into his compact rappresentation, it is the
+// opposite of CompactToBig.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tmp := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(tmp.Uint64())
+	}
+
+	//the 0x00800000 bit is the sign, so we move the mantissa of one byte
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	return uint32(exponent<<24) | mantissa
+}
+
+// BlockWork return the number of hashes that are needed on average to mine
+// a block with this bits: 2^256 / (target+1)
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// NextBits calculate the target of a new block from the block before it (prev)
+// and the first block of the interval (first). It is called only when the
+// height of the new block is a multiple of RetargetInterval.
+func NextBits(prev, first *Block) uint32 {
+	expectedTimespan := int64(TargetBlockTime * (prev.Height - first.Height))
+	actualTimespan := prev.Timestamp - first.Timestamp
+
+	//limit the adjustment of the difficulty to a factor of 4
+	if actualTimespan < expectedTimespan/4 {
+		actualTimespan = expectedTimespan / 4
+	}
+	if actualTimespan > expectedTimespan*4 {
+		actualTimespan = expectedTimespan * 4
+	}
+
+	target := CompactToBig(prev.Bits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(expectedTimespan))
+
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+
+	return BigToCompact(target)
+}
+
 // InitData Derive the hash based on the previous Hash and the current data.
 // is it like DeriveHash but with our HashFunction
 // Create our counter or nonce (2)
 func (pow *ProofOfWork) InitData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PrevHash,
-			pow.Block.HashTransactions(),
-			ToHex(int64(nonce)),
-			ToHex(int64(Difficulty)),
-		},
-		[]byte{},
-	)
-	return data
-
+	return pow.Header.hashData(nonce)
 }
 
 // ToHex is utility function that convert int64 to a []byte organized in BigEndian form
 func ToHex(num int64) []byte {
-	buff := new(bytes.Buffer)
+	buff := make([]byte, 8)
 	//take our nummber (num) and decode into bytes
-	err := binary.Write(buff, binary.BigEndian, num)
-	if err != nil {
-		log.Panic(err)
-	}
-	return buff.Bytes()
+	binary.BigEndian.PutUint64(buff, uint64(num))
+	return buff
 }
 
 // Run Create the Hash from the counter plus the data and
-// then check if the hash meets a set of requirements
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var intHash big.Int
-	var hash [32]byte
-	nonce := 0
-	//prepare the data, then hash it into a sha-256 format than
-	//convert that hash into a big integer and
-	//compare that big integer with our target big integer
-	for nonce < math.MaxInt64 {
-		data := pow.InitData(nonce)
-		hash = sha256.Sum256(data)
-		//fmt.Printf("\r%x", hash)
-
-		intHash.SetBytes(hash[:]) //convert our hash into a bigInteger
-		//compare our hash with the target and if the come back with -1 we break
-		//out of loop because that mean that our hash is less than the target we're looking for
-		//which means that we've actually signed the block
-		if intHash.Cmp(pow.Target) == -1 {
-			break
-		}
-
-		nonce++
+// then check if the hash meets a set of requirements.
+// The nonces from 0 to MaxNonce are split between runtime.NumCPU() goroutines,
+// the goroutine i tries i, i+n, i+2n... Run returns ErrNonceExhausted if no
+// nonce meets the target and the error of ctx if ctx is done before.
+func (pow *ProofOfWork) Run(ctx context.Context) (int, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		nonce int
+		hash  []byte
+	}
+	found := make(chan result, 1)
+	workers := runtime.NumCPU()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			var intHash big.Int
+			var hashes uint64
+			defer func() { atomic.AddUint64(&pow.Hashes, hashes) }()
+
+			for nonce := uint64(start); nonce <= MaxNonce; nonce += uint64(workers) {
+				//check the context only sometimes, it is slower than a hash
+				if hashes%1024 == 0 && ctx.Err() != nil {
+					return
+				}
+
+				//prepare the data, then hash it into a sha-256 format than
+				//convert that hash into a big integer and
+				//compare that big integer with our target big integer
+				hash := sha256.Sum256(pow.InitData(int(nonce)))
+				hashes++
+				intHash.SetBytes(hash[:])
+				if intHash.Cmp(pow.Target) == -1 {
+					//the first goroutine that finds a nonce stops the others
+					select {
+					case found <- result{int(nonce), hash[:]}:
+						cancel()
+					default:
+					}
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case r := <-found:
+		return r.nonce, r.hash, nil
+	default:
 	}
-	fmt.Println()
-	return nonce, hash[:]
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return 0, nil, ErrNonceExhausted
 }
 
 // Validate After Run() function will have nonce wich allow us to derive the hash, which
@@ -101,12 +213,17 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
 
-	data := pow.InitData(pow.Block.Nonce) //take the data
+	data := pow.InitData(pow.Header.Nonce) //take the data
 
 	hash := sha256.Sum256(data) //convert data into hash
 
 	intHash.SetBytes(hash[:]) //convert hash into bigInt a put it into intHash variable
 
+	//the target can't be easier than the powLimit
+	if pow.Target.Sign() <= 0 || pow.Target.Cmp(powLimit) > 0 {
+		return false
+	}
+
 	//check ig the block itself is valid
 	return intHash.Cmp(pow.Target) == -1
 }
@@ -0,0 +1,655 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// The outputs are locked by a script, the ScriptPubKey, and the inputs unlock
+// them with another script, the ScriptSig. A script is a list of opcodes that
+// work on a stack of byte slices: the ScriptSig is run first and it can only
+// push data, then the ScriptPubKey of the spent output is run on the same
+// stack, and the input can spend the output if the value left on the top of
+// the stack is true. The opcodes have the values of the Bitcoin opcodes.
+//
+// The addresses of the wallets use P2PKH (pay to public key hash):
+//
+//	ScriptPubKey  OP_DUP OP_HASH160 <public key hash> OP_EQUALVERIFY OP_CHECKSIG
+//	ScriptSig     <signature> <public key>
+//
+// The addresses of the scripts use P2SH (pay to script hash): the output is
+// locked to the hash of a redeem script, and the ScriptSig pushes the redeem
+// script after the values that it needs. When the hash matches, the redeem
+// script is run on the values. The multisig addresses have the redeem script
+// of MultisigScript:
+//
+//	ScriptPubKey  OP_HASH160 <script hash> OP_EQUAL
+//	ScriptSig     <signature 1> ... <signature m> <m <key 1> ... <key n> n OP_CHECKMULTISIG>
+const (
+	Op0                   byte = 0x00 //push an empty value, the false
+	OpPushData1           byte = 0x4c //push the data, the length is on 1 byte
+	OpPushData2           byte = 0x4d //push the data, the length is on 2 bytes
+	OpPushData4           byte = 0x4e //push the data, the length is on 4 bytes
+	Op1                   byte = 0x51 //push the number 1, until Op16
+	Op16                  byte = 0x60
+	OpReturn              byte = 0x6a //fail, the output can't be spent (e.g. it only contains data)
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpHash160             byte = 0xa9 //ripemd160 of the sha256, like wallet.PublicKeyHash
+	OpCheckSig            byte = 0xac
+	OpCheckMultiSig       byte = 0xae
+	OpCheckLockTimeVerify byte = 0xb1
+	OpCheckSequenceVerify byte = 0xb2
+)
+
+// opNames are the names of the opcodes printed by ScriptString.
+var opNames = map[byte]string{
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckMultiSig:       "OP_CHECKMULTISIG",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+}
+
+const (
+	maxElementSize  = 520 //bytes of a value pushed by an executed script
+	maxMultiSigKeys = 20
+)
+
+// scriptError return an error that wraps ErrScriptFailed.
+func scriptError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrScriptFailed, fmt.Sprintf(format, a...))
+}
+
+// instruction is an opcode of a script with the data that it pushes.
+type instruction struct {
+	op   byte
+	data []byte
+}
+
+// isPush return true if op pushes data, Op0 pushes an empty value.
+func isPush(op byte) bool {
+	return op <= OpPushData4
+}
+
+// pushOpcode return the opcode that pushes n bytes, the shortest one.
+func pushOpcode(n int) byte {
+	switch {
+	case n < int(OpPushData1):
+		return byte(n)
+	case n <= 0xff:
+		return OpPushData1
+	case n <= 0xffff:
+		return OpPushData2
+	default:
+		return OpPushData4
+	}
+}
+
+// pushData append to script the opcode that pushes data.
+func pushData(script, data []byte) []byte {
+	n := len(data)
+	switch op := pushOpcode(n); op {
+	case OpPushData1:
+		script = append(script, op, byte(n))
+	case OpPushData2:
+		script = append(script, op, byte(n), byte(n>>8))
+	case OpPushData4:
+		script = append(script, op, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	default:
+		script = append(script, op)
+	}
+
+	return append(script, data...)
+}
+
+// pushNumber append to script the opcode that pushes the number n.
+func pushNumber(script []byte, n int64) []byte {
+	switch {
+	case n == 0:
+		return append(script, Op0)
+	case n >= 1 && n <= 16:
+		return append(script, Op1+byte(n-1))
+	default:
+		return pushData(script, encodeNumber(n))
+	}
+}
+
+// parseScript split the script into instructions, the data must be pushed
+// with the shortest opcode so a script has only one encoding.
+func parseScript(script []byte) ([]instruction, error) {
+	var instructions []instruction
+	for len(script) > 0 {
+		op := script[0]
+		script = script[1:]
+		if !isPush(op) {
+			instructions = append(instructions, instruction{op: op})
+			continue
+		}
+
+		//the length is the opcode, or it follows the OpPushData
+		n, size := int(op), 0
+		switch op {
+		case OpPushData1:
+			size = 1
+		case OpPushData2:
+			size = 2
+		case OpPushData4:
+			size = 4
+		}
+		if len(script) < size {
+			return nil, scriptError("Push length is missing")
+		}
+		if size > 0 {
+			length := make([]byte, 4)
+			copy(length, script[:size])
+			n = int(binary.LittleEndian.Uint32(length))
+			script = script[size:]
+		}
+		if n > len(script) {
+			return nil, scriptError("Push of %d bytes is longer than the script", n)
+		}
+		if pushOpcode(n) != op {
+			return nil, scriptError("Push of %d bytes doesn't use the shortest opcode", n)
+		}
+		ins := instruction{op: op}
+		if n > 0 {
+			ins.data = script[:n]
+		}
+		instructions = append(instructions, ins)
+		script = script[n:]
+	}
+
+	return instructions, nil
+}
+
+// isPushOnly return true if the script only pushes data and numbers.
+func isPushOnly(script []byte) bool {
+	instructions, err := parseScript(script)
+	if err != nil {
+		return false
+	}
+	for _, ins := range instructions {
+		if !isPush(ins.op) && (ins.op < Op1 || ins.op > Op16) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ScriptString return the script in a readable form, e.g.
+// "OP_DUP OP_HASH160 <hex> OP_EQUALVERIFY OP_CHECKSIG".
+func ScriptString(script []byte) string {
+	instructions, err := parseScript(script)
+	if err != nil {
+		return fmt.Sprintf("[%s] %x", err, script)
+	}
+
+	var words []string
+	for _, ins := range instructions {
+		switch {
+		case ins.op == Op0:
+			words = append(words, "0")
+		case isPush(ins.op):
+			words = append(words, hex.EncodeToString(ins.data))
+		case ins.op >= Op1 && ins.op <= Op16:
+			words = append(words, fmt.Sprint(ins.op-Op1+1))
+		case opNames[ins.op] != "":
+			words = append(words, opNames[ins.op])
+		default:
+			words = append(words, fmt.Sprintf("OP_UNKNOWN_%02x", ins.op))
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// P2PKHScript return the ScriptPubKey that locks an output to pubKeyHash.
+func P2PKHScript(pubKeyHash []byte) []byte {
+	script := pushData([]byte{OpDup, OpHash160}, pubKeyHash)
+	return append(script, OpEqualVerify, OpCheckSig)
+}
+
+// P2PKHPubKeyHash return the public key hash of a P2PKH script, false if the
+// script is not P2PKH.
+func P2PKHPubKeyHash(script []byte) ([]byte, bool) {
+	ins, err := parseScript(script)
+	if err != nil || len(ins) != 5 || ins[0].op != OpDup || ins[1].op != OpHash160 ||
+		!isPush(ins[2].op) || ins[3].op != OpEqualVerify || ins[4].op != OpCheckSig {
+		return nil, false
+	}
+
+	return ins[2].data, true
+}
+
+// P2SHScript return the ScriptPubKey that locks an output to the hash of a script.
+func P2SHScript(scriptHash []byte) []byte {
+	script := pushData([]byte{OpHash160}, scriptHash)
+	return append(script, OpEqual)
+}
+
+// P2SHScriptHash return the script hash of a P2SH script, false if the script
+// is not P2SH.
+func P2SHScriptHash(script []byte) ([]byte, bool) {
+	ins, err := parseScript(script)
+	if err != nil || len(ins) != 3 || ins[0].op != OpHash160 || !isPush(ins[1].op) || ins[2].op != OpEqual {
+		return nil, false
+	}
+
+	return ins[1].data, true
+}
+
+// MultisigScript return the redeem script that needs the signatures of
+// required keys of pubKeys. The ScriptSig pushes the script, so it can't be
+// longer than a push: 7 keys of 64 bytes at most.
+func MultisigScript(required int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > 16 || required < 1 || required > n {
+		return nil, fmt.Errorf("Multisig needs 1 to 16 keys and 1 to %d signatures, not %d of %d", n, required, n)
+	}
+
+	script := pushNumber(nil, int64(required))
+	for _, pubKey := range pubKeys {
+		if !wallet.ValidPublicKey(pubKey) {
+			return nil, fmt.Errorf("Public key %x is not valid", pubKey)
+		}
+		script = pushData(script, pubKey)
+	}
+	script = append(pushNumber(script, int64(n)), OpCheckMultiSig)
+
+	if len(script) > maxElementSize {
+		return nil, fmt.Errorf("Multisig script of %d keys is %d bytes, the maximum is %d", n, len(script), maxElementSize)
+	}
+
+	return script, nil
+}
+
+// ParseMultisigScript return the signatures required by a script made by
+// MultisigScript and its keys, false if it is not a multisig script.
+func ParseMultisigScript(script []byte) (int, [][]byte, bool) {
+	ins, err := parseScript(script)
+	if err != nil || len(ins) < 4 || ins[len(ins)-1].op != OpCheckMultiSig {
+		return 0, nil, false
+	}
+
+	smallNumber := func(op byte) int {
+		if op < Op1 || op > Op16 {
+			return 0
+		}
+		return int(op-Op1) + 1
+	}
+	required, n := smallNumber(ins[0].op), smallNumber(ins[len(ins)-2].op)
+	if required == 0 || required > n || n != len(ins)-3 {
+		return 0, nil, false
+	}
+
+	var pubKeys [][]byte
+	for _, key := range ins[1 : len(ins)-2] {
+		if !isPush(key.op) {
+			return 0, nil, false
+		}
+		pubKeys = append(pubKeys, key.data)
+	}
+
+	return required, pubKeys, true
+}
+
+// sigScript return the ScriptSig that spends a P2PKH output.
+func sigScript(signature, pubKey []byte) []byte {
+	return pushData(pushData(nil, signature), pubKey)
+}
+
+// sigScriptParts return the signature and the public key pushed by a
+// ScriptSig made by sigScript, nil if the script doesn't push two values.
+func sigScriptParts(script []byte) ([]byte, []byte) {
+	ins, err := parseScript(script)
+	if err != nil || len(ins) != 2 || !isPush(ins[0].op) || !isPush(ins[1].op) {
+		return nil, nil
+	}
+
+	return ins[0].data, ins[1].data
+}
+
+// encodeNumber return n in the format of the numbers of the scripts: little
+// endian in the shortest form, with the sign in the highest bit of the last byte.
+func encodeNumber(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+	var b []byte
+	for ; abs > 0; abs >>= 8 {
+		b = append(b, byte(abs))
+	}
+
+	switch {
+	case b[len(b)-1]&0x80 != 0 && negative:
+		b = append(b, 0x80)
+	case b[len(b)-1]&0x80 != 0:
+		b = append(b, 0)
+	case negative:
+		b[len(b)-1] |= 0x80
+	}
+
+	return b
+}
+
+// decodeNumber read a number written by encodeNumber that is at most maxLength bytes.
+func decodeNumber(b []byte, maxLength int) (int64, error) {
+	if len(b) > maxLength {
+		return 0, scriptError("Number is longer than %d bytes", maxLength)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	//the last byte can contain only the sign if the byte before needs the high bit
+	if b[len(b)-1]&0x7f == 0 && (len(b) == 1 || b[len(b)-2]&0x80 == 0) {
+		return 0, scriptError("Number is not in the shortest form")
+	}
+
+	var n int64
+	for i, c := range b {
+		n |= int64(c) << (8 * uint(i))
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * uint(len(b)-1))
+		n = -n
+	}
+
+	return n, nil
+}
+
+// asBool return false for the empty values, the zeros and the negative zeros.
+func asBool(v []byte) bool {
+	for i, b := range v {
+		if b != 0 {
+			return i != len(v)-1 || b != 0x80
+		}
+	}
+
+	return false
+}
+
+// scriptVM run the scripts of an input of tx.
+type scriptVM struct {
+	stack [][]byte
+	tx    *Transaction
+	input int //index of the input inside tx
+}
+
+func (vm *scriptVM) push(v []byte) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *scriptVM) pushBool(b bool) {
+	if b {
+		vm.push([]byte{1})
+	} else {
+		vm.push(nil)
+	}
+}
+
+// popN remove the last n values of the stack and return them in the order
+// they were pushed.
+func (vm *scriptVM) popN(n int) ([][]byte, error) {
+	if n > len(vm.stack) {
+		return nil, scriptError("Stack has %d values, %d are needed", len(vm.stack), n)
+	}
+	values := vm.stack[len(vm.stack)-n:]
+	vm.stack = vm.stack[:len(vm.stack)-n]
+
+	return values, nil
+}
+
+func (vm *scriptVM) pop() ([]byte, error) {
+	values, err := vm.popN(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0], nil
+}
+
+func (vm *scriptVM) popNumber(maxLength int) (int64, error) {
+	v, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeNumber(v, maxLength)
+}
+
+// run execute script on the stack of vm.
+func (vm *scriptVM) run(script []byte) error {
+	instructions, err := parseScript(script)
+	if err != nil {
+		return err
+	}
+
+	for _, ins := range instructions {
+		switch op := ins.op; {
+		case isPush(op):
+			if len(ins.data) > maxElementSize {
+				return scriptError("Push of %d bytes is greater than %d", len(ins.data), maxElementSize)
+			}
+			vm.push(ins.data)
+		case op >= Op1 && op <= Op16:
+			vm.push(encodeNumber(int64(op - Op1 + 1)))
+		case op == OpReturn:
+			return scriptError("OP_RETURN, the output can't be spent")
+		case op == OpDrop:
+			if _, err := vm.pop(); err != nil {
+				return err
+			}
+		case op == OpDup:
+			v, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			vm.push(v)
+			vm.push(v)
+		case op == OpEqual:
+			values, err := vm.popN(2)
+			if err != nil {
+				return err
+			}
+			vm.pushBool(bytes.Equal(values[0], values[1]))
+		case op == OpEqualVerify:
+			values, err := vm.popN(2)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(values[0], values[1]) {
+				return scriptError("OP_EQUALVERIFY, the values are not equal")
+			}
+		case op == OpHash160:
+			v, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			vm.push(wallet.PublicKeyHash(v))
+		case op == OpCheckSig:
+			values, err := vm.popN(2)
+			if err != nil {
+				return err
+			}
+			hash := vm.tx.signatureHash(vm.input, script)
+			vm.pushBool(wallet.Verify(values[1], hash, values[0]))
+		case op == OpCheckMultiSig:
+			ok, err := vm.checkMultiSig(script)
+			if err != nil {
+				return err
+			}
+			vm.pushBool(ok)
+		case op == OpCheckLockTimeVerify:
+			if err := vm.checkLockTime(); err != nil {
+				return err
+			}
+		case op == OpCheckSequenceVerify:
+			if err := vm.checkSequence(); err != nil {
+				return err
+			}
+		default:
+			return scriptError("Opcode 0x%02x is not known", op)
+		}
+	}
+
+	return nil
+}
+
+// checkMultiSig check the stack "<sig 1> ... <sig m> m <key 1> ... <key n> n":
+// there must be m valid signatures, in the same order of the keys.
+func (vm *scriptVM) checkMultiSig(script []byte) (bool, error) {
+	n, err := vm.popNumber(4)
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > maxMultiSigKeys {
+		return false, scriptError("OP_CHECKMULTISIG with %d keys", n)
+	}
+	keys, err := vm.popN(int(n))
+	if err != nil {
+		return false, err
+	}
+	m, err := vm.popNumber(4)
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, scriptError("OP_CHECKMULTISIG with %d signatures and %d keys", m, n)
+	}
+	signatures, err := vm.popN(int(m))
+	if err != nil {
+		return false, err
+	}
+
+	hash := vm.tx.signatureHash(vm.input, script)
+	k := 0
+	for _, signature := range signatures {
+		for k < len(keys) && !wallet.Verify(keys[k], hash, signature) {
+			k++
+		}
+		if k == len(keys) {
+			return false, nil
+		}
+		k++
+	}
+
+	return true, nil
+}
+
+// checkLockTime check that the LockTime of the transaction is at least the
+// lock time on the top of the stack, both heights or both times. The value
+// is left on the stack.
+func (vm *scriptVM) checkLockTime() error {
+	if len(vm.stack) == 0 {
+		return scriptError("OP_CHECKLOCKTIMEVERIFY with an empty stack")
+	}
+	lockTime, err := decodeNumber(vm.stack[len(vm.stack)-1], 5)
+	if err != nil {
+		return err
+	}
+	if lockTime < 0 {
+		return scriptError("OP_CHECKLOCKTIMEVERIFY with a negative lock time")
+	}
+
+	txLockTime := int64(vm.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return scriptError("Lock time %d and the LockTime %d of the transaction are not of the same kind", lockTime, txLockTime)
+	}
+	if lockTime > txLockTime {
+		return scriptError("Output is locked until %d, the LockTime of the transaction is %d", lockTime, txLockTime)
+	}
+
+	return nil
+}
+
+// checkSequence check that the Sequence of the input is at least the relative
+// lock on the top of the stack, both blocks or both times, so the output can be
+// spent only when it is old enough. The value is left on the stack.
+func (vm *scriptVM) checkSequence() error {
+	if len(vm.stack) == 0 {
+		return scriptError("OP_CHECKSEQUENCEVERIFY with an empty stack")
+	}
+	lock, err := decodeNumber(vm.stack[len(vm.stack)-1], 5)
+	if err != nil {
+		return err
+	}
+	if lock < 0 {
+		return scriptError("OP_CHECKSEQUENCEVERIFY with a negative lock")
+	}
+	if vm.tx.Version < SequenceTxVersion {
+		return scriptError("OP_CHECKSEQUENCEVERIFY needs a transaction of version %d", SequenceTxVersion)
+	}
+
+	sequence := int64(vm.tx.Inputs[vm.input].Sequence)
+	if lock&SequenceTypeFlag != sequence&SequenceTypeFlag {
+		return scriptError("Lock %x and the Sequence %x of the input are not of the same kind", lock, sequence)
+	}
+	if lock&SequenceMask > sequence&SequenceMask {
+		return scriptError("Output is locked for %d, the Sequence of the input is %d", lock&SequenceMask, sequence&SequenceMask)
+	}
+
+	return nil
+}
+
+// result check that the value on the top of the stack is true.
+func (vm *scriptVM) result() error {
+	if len(vm.stack) == 0 || !asBool(vm.stack[len(vm.stack)-1]) {
+		return scriptError("Result is false")
+	}
+
+	return nil
+}
+
+// verifyInput run the ScriptSig of the input of tx at index and then the
+// scriptPubKey of the output that it spends. If the output is P2SH the redeem
+// script is run too, on the other values pushed by the ScriptSig.
+func (tx *Transaction) verifyInput(index int, scriptPubKey []byte) error {
+	scriptSig := tx.Inputs[index].ScriptSig
+	if !isPushOnly(scriptSig) {
+		return scriptError("ScriptSig must only push data")
+	}
+
+	vm := &scriptVM{tx: tx, input: index}
+	if err := vm.run(scriptSig); err != nil {
+		return err
+	}
+	pushed := append([][]byte{}, vm.stack...)
+	if err := vm.run(scriptPubKey); err != nil {
+		return err
+	}
+	if err := vm.result(); err != nil {
+		return err
+	}
+
+	if _, ok := P2SHScriptHash(scriptPubKey); !ok {
+		return nil
+	}
+	//the hash is equal, so the ScriptSig has pushed the redeem script at the end
+	redeemScript := pushed[len(pushed)-1]
+	vm = &scriptVM{stack: pushed[:len(pushed)-1], tx: tx, input: index}
+	if err := vm.run(redeemScript); err != nil {
+		return err
+	}
+
+	return vm.result()
+}
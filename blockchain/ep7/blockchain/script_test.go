@@ -0,0 +1,338 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// makeWallets return n new wallets.
+func makeWallets(t *testing.T, n int) []*wallet.Wallet {
+	var wallets []*wallet.Wallet
+	for i := 0; i < n; i++ {
+		w, err := wallet.MakeWallet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallets = append(wallets, w)
+	}
+
+	return wallets
+}
+
+// walletsOf return a wallet file with the wallets and the scripts.
+func walletsOf(wallets []*wallet.Wallet, scripts ...[]byte) *wallet.Wallets {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	for _, w := range wallets {
+		ws.Wallets[string(w.Address())] = w
+	}
+	for _, script := range scripts {
+		ws.AddScript(script)
+	}
+
+	return ws
+}
+
+// spendTx return an unsigned transaction that spends an output locked by
+// script, with the map of the transaction of that output.
+func spendTx(script []byte) (*Transaction, map[string]Transaction) {
+	prevTX := Transaction{
+		Inputs:  []TxInput{{ID: make([]byte, 32), Out: 0}},
+		Outputs: []TxOutput{{Value: 10, ScriptPubKey: script}},
+		Version: TxVersion,
+	}
+	prevTX.SetID()
+
+	tx := &Transaction{
+		Inputs:  []TxInput{{ID: prevTX.ID, Out: 0}},
+		Outputs: []TxOutput{{Value: 9, ScriptPubKey: script}},
+		Version: TxVersion,
+	}
+	tx.SetID()
+
+	return tx, map[string]Transaction{hex.EncodeToString(prevTX.ID): prevTX}
+}
+
+func TestP2PKH(t *testing.T) {
+	wallets := makeWallets(t, 2)
+	owner, other := wallets[0], wallets[1]
+	script := P2PKHScript(wallet.PublicKeyHash(owner.PublicKey))
+
+	tests := []struct {
+		name   string
+		signer *wallet.Wallet
+		change func(tx *Transaction) //change the transaction after the signature
+		ok     bool
+	}{
+		{name: "signed by the owner", signer: owner, ok: true},
+		{name: "signed by another wallet", signer: other},
+		{name: "not signed"},
+		{name: "output changed after the signature", signer: owner, change: func(tx *Transaction) {
+			tx.Outputs[0].Value++
+		}},
+		{name: "signature of another key", signer: owner, change: func(tx *Transaction) {
+			signature, err := wallet.Sign(&other.PrivateKey, tx.signatureHash(0, script))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.Inputs[0].ScriptSig = sigScript(signature, owner.PublicKey)
+		}},
+		{name: "public key of another wallet", signer: owner, change: func(tx *Transaction) {
+			signature, _ := sigScriptParts(tx.Inputs[0].ScriptSig)
+			tx.Inputs[0].ScriptSig = sigScript(signature, other.PublicKey)
+		}},
+		{name: "ScriptSig with an opcode", signer: owner, change: func(tx *Transaction) {
+			tx.Inputs[0].ScriptSig = append(tx.Inputs[0].ScriptSig, OpDup, OpDrop)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, prevTXs := spendTx(script)
+			if test.signer != nil {
+				if err := tx.Sign(walletsOf([]*wallet.Wallet{test.signer}), prevTXs); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.change != nil {
+				test.change(tx)
+			}
+
+			err := tx.Verify(prevTXs)
+			if test.ok && err != nil {
+				t.Errorf("Verify = %v, want nil", err)
+			}
+			if !test.ok && !errors.Is(err, ErrScriptFailed) {
+				t.Errorf("Verify = %v, want ErrScriptFailed", err)
+			}
+		})
+	}
+}
+
+// swapSignatures swap the first two signatures of a multisig ScriptSig.
+func swapSignatures(t *testing.T, tx *Transaction) {
+	ins, err := parseScript(tx.Inputs[0].ScriptSig)
+	if err != nil || len(ins) < 3 {
+		t.Fatalf("ScriptSig %s doesn't have two signatures", ScriptString(tx.Inputs[0].ScriptSig))
+	}
+	ins[0], ins[1] = ins[1], ins[0]
+
+	var scriptSig []byte
+	for _, in := range ins {
+		scriptSig = pushData(scriptSig, in.data)
+	}
+	tx.Inputs[0].ScriptSig = scriptSig
+}
+
+func TestMultisig(t *testing.T) {
+	wallets := makeWallets(t, 4)
+
+	tests := []struct {
+		name     string
+		required int
+		keys     int
+		signers  []int //the wallets that sign one after the other
+		change   func(tx *Transaction)
+		ok       bool
+	}{
+		{name: "1 of 1", required: 1, keys: 1, signers: []int{0}, ok: true},
+		{name: "1 of 3 by the last key", required: 1, keys: 3, signers: []int{2}, ok: true},
+		{name: "2 of 3 by the first keys", required: 2, keys: 3, signers: []int{0, 1}, ok: true},
+		{name: "2 of 3 by the last keys", required: 2, keys: 3, signers: []int{2, 1}, ok: true},
+		{name: "2 of 3 by the first and the last key", required: 2, keys: 3, signers: []int{2, 0}, ok: true},
+		{name: "3 of 3", required: 3, keys: 3, signers: []int{1, 2, 0}, ok: true},
+		{name: "2 of 3 signed by all", required: 2, keys: 3, signers: []int{0, 1, 2}, ok: true},
+		{name: "2 of 3 with one signature", required: 2, keys: 3, signers: []int{1}},
+		{name: "2 of 3 not signed", required: 2, keys: 3},
+		{name: "2 of 3 signed by another wallet", required: 2, keys: 3, signers: []int{0, 3}},
+		{name: "2 of 3 with the signatures in the wrong order", required: 2, keys: 3, signers: []int{0, 2},
+			change: func(tx *Transaction) { swapSignatures(t, tx) }},
+		{name: "2 of 3 with the same signature twice", required: 2, keys: 3, signers: []int{0, 1},
+			change: func(tx *Transaction) {
+				ins, _ := parseScript(tx.Inputs[0].ScriptSig)
+				scriptSig := pushData(pushData(nil, ins[0].data), ins[0].data)
+				tx.Inputs[0].ScriptSig = pushData(scriptSig, ins[2].data)
+			}},
+		{name: "2 of 3 with the output changed", required: 2, keys: 3, signers: []int{0, 1},
+			change: func(tx *Transaction) { tx.Outputs[0].Value-- }},
+		{name: "2 of 3 with another redeem script", required: 2, keys: 3, signers: []int{0, 1},
+			change: func(tx *Transaction) {
+				ins, _ := parseScript(tx.Inputs[0].ScriptSig)
+				other, err := MultisigScript(1, [][]byte{wallets[0].PublicKey})
+				if err != nil {
+					t.Fatal(err)
+				}
+				tx.Inputs[0].ScriptSig = pushData(pushData(nil, ins[0].data), other)
+			}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pubKeys [][]byte
+			for _, w := range wallets[:test.keys] {
+				pubKeys = append(pubKeys, w.PublicKey)
+			}
+			redeemScript, err := MultisigScript(test.required, pubKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if required, keys, ok := ParseMultisigScript(redeemScript); !ok || required != test.required || len(keys) != test.keys {
+				t.Fatalf("ParseMultisigScript = %d, %d keys, %v", required, len(keys), ok)
+			}
+
+			tx, prevTXs := spendTx(P2SHScript(wallet.PublicKeyHash(redeemScript)))
+			for _, signer := range test.signers {
+				if err := tx.Sign(walletsOf(wallets[signer:signer+1], redeemScript), prevTXs); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.change != nil {
+				test.change(tx)
+			}
+
+			err = tx.Verify(prevTXs)
+			if test.ok && err != nil {
+				t.Errorf("Verify = %v, want nil", err)
+			}
+			if !test.ok && !errors.Is(err, ErrScriptFailed) {
+				t.Errorf("Verify = %v, want ErrScriptFailed", err)
+			}
+		})
+	}
+}
+
+func TestMultisigScriptLimits(t *testing.T) {
+	wallets := makeWallets(t, 8)
+	var pubKeys [][]byte
+	for _, w := range wallets {
+		pubKeys = append(pubKeys, w.PublicKey)
+	}
+
+	tests := []struct {
+		required, keys int
+		ok             bool
+	}{
+		{1, 1, true},
+		{7, 7, true},
+		{0, 1, false},
+		{2, 1, false},
+		{1, 0, false},
+		{1, 8, false}, //the script is longer than a push
+	}
+	for _, test := range tests {
+		_, err := MultisigScript(test.required, pubKeys[:test.keys])
+		if (err == nil) != test.ok {
+			t.Errorf("MultisigScript(%d of %d) = %v, want ok %v", test.required, test.keys, err, test.ok)
+		}
+	}
+
+	if _, err := MultisigScript(1, [][]byte{[]byte("not a key")}); err == nil {
+		t.Error("MultisigScript accepted a public key that is not valid")
+	}
+}
+
+// lockScript return a ScriptPubKey that checks lock with op and leaves true on the stack.
+func lockScript(op byte, lock int64) []byte {
+	return append(pushNumber(nil, lock), op, OpDrop, Op1)
+}
+
+func TestCheckLockTimeVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		lockTime uint32 //LockTime of the transaction
+		lock     int64  //lock time of the script
+		ok       bool
+	}{
+		{"same height", 100, 100, true},
+		{"height after the lock", 100, 99, true},
+		{"height before the lock", 100, 101, false},
+		{"last height", LockTimeThreshold - 1, LockTimeThreshold - 1, true},
+		{"height with a time lock", LockTimeThreshold - 1, LockTimeThreshold, false},
+		{"first time", LockTimeThreshold, LockTimeThreshold, true},
+		{"time with a height lock", LockTimeThreshold, LockTimeThreshold - 1, false},
+		{"time before the lock", LockTimeThreshold + 10, LockTimeThreshold + 11, false},
+		{"last time", 0xffffffff, 0xffffffff, true},
+		{"negative lock", 100, -1, false},
+		{"lock of 6 bytes", 0xffffffff, 1 << 40, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := lockScript(OpCheckLockTimeVerify, test.lock)
+			tx, _ := spendTx(script)
+			tx.LockTime = test.lockTime
+
+			err := tx.verifyInput(0, script)
+			if test.ok && err != nil {
+				t.Errorf("verifyInput = %v, want nil", err)
+			}
+			if !test.ok && !errors.Is(err, ErrScriptFailed) {
+				t.Errorf("verifyInput = %v, want ErrScriptFailed", err)
+			}
+		})
+	}
+}
+
+func TestCheckSequenceVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  int
+		sequence uint32 //Sequence of the input
+		lock     int64  //relative lock of the script
+		ok       bool
+	}{
+		{"same blocks", TxVersion, 10, 10, true},
+		{"more blocks", TxVersion, 11, 10, true},
+		{"less blocks", TxVersion, 9, 10, false},
+		{"same time", TxVersion, SequenceTypeFlag | 10, SequenceTypeFlag | 10, true},
+		{"less time", TxVersion, SequenceTypeFlag | 9, SequenceTypeFlag | 10, false},
+		{"blocks with a time lock", TxVersion, 10, SequenceTypeFlag | 10, false},
+		{"time with a blocks lock", TxVersion, SequenceTypeFlag | 10, 10, false},
+		{"last blocks", TxVersion, SequenceMask, SequenceMask, true},
+		{"bits out of the mask", TxVersion, 10, 1<<16 | 10, true},
+		{"negative lock", TxVersion, 10, -1, false},
+		{"version without the Sequence", ScriptTxVersion, 10, 10, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := lockScript(OpCheckSequenceVerify, test.lock)
+			tx, _ := spendTx(script)
+			tx.Version = test.version
+			tx.Inputs[0].Sequence = test.sequence
+
+			err := tx.verifyInput(0, script)
+			if test.ok && err != nil {
+				t.Errorf("verifyInput = %v, want nil", err)
+			}
+			if !test.ok && !errors.Is(err, ErrScriptFailed) {
+				t.Errorf("verifyInput = %v, want ErrScriptFailed", err)
+			}
+		})
+	}
+}
+
+func TestIsFinal(t *testing.T) {
+	tests := []struct {
+		lockTime  uint32
+		height    int
+		blockTime int64
+		final     bool
+	}{
+		{0, 0, 0, true},
+		{100, 100, 0, false},
+		{100, 101, 0, true},
+		{LockTimeThreshold, 1000, LockTimeThreshold, false},
+		{LockTimeThreshold, 1000, LockTimeThreshold + 1, true},
+	}
+
+	for _, test := range tests {
+		tx := Transaction{LockTime: test.lockTime}
+		if final := tx.IsFinal(test.height, test.blockTime); final != test.final {
+			t.Errorf("IsFinal of LockTime %d at height %d and time %d = %v, want %v",
+				test.lockTime, test.height, test.blockTime, final, test.final)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package blockchain
+
+import "errors"
+
+// ErrKeyNotFound is returned by a Store when the key is not saved.
+var ErrKeyNotFound = errors.New("Key not found")
+
+// Store is the key-value storage used by BlockChain, BlockChainIterator and
+// UTXOSet. The keys of the different data are separated by their prefix,
+// because the stores don't have tables.
+type Store interface {
+	// Get return a copy of the value of key, or ErrKeyNotFound (not wrapped).
+	Get(key []byte) ([]byte, error)
+	// Put save value with key.
+	Put(key, value []byte) error
+	// Delete remove key, it is not an error if the key doesn't exist.
+	Delete(key []byte) error
+	// Iterate call fn for every key that starts with prefix, in the order of
+	// the keys. The store can't be changed inside fn.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+	// Batch run fn and save all its writes together, or none if fn fails.
+	Batch(fn func(batch Batch) error) error
+	// BulkWrite run fn and save its writes in the fastest way, for a lot of
+	// writes that don't fit in a Batch (e.g. a reindex). The writes are not
+	// atomic, if fn fails a part of them may be saved. The store can't be
+	// used inside fn.
+	BulkWrite(fn func(w Writer) error) error
+	// Close release the store, it can't be used anymore.
+	Close() error
+}
+
+// Writer is the writes of a BulkWrite.
+type Writer interface {
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// Batch is a group of writes of a Store, Get see the writes done before it
+// in the same batch.
+type Batch interface {
+	Writer
+	Get(key []byte) ([]byte, error)
+}
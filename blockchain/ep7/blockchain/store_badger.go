@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// GCInterval is how often a BadgerStore clean the value log, Badger never
+// removes the old values by itself.
+var GCInterval = 5 * time.Minute
+
+// BadgerStore is a Store saved on the disk with a Badger db.
+type BadgerStore struct {
+	db   *badger.DB
+	stop chan struct{} //closed to stop the gc
+	done chan struct{} //closed when the gc is stopped
+}
+
+// NewBadgerStore open the Badger db with opts, opts.Dir and opts.ValueDir
+// must be set. The value log is cleaned every GCInterval until Close.
+func NewBadgerStore(opts badger.Options) (*BadgerStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BadgerStore{db: db, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.runGC()
+
+	return s, nil
+}
+
+// runGC clean the value log every GCInterval until the store is closed.
+func (s *BadgerStore) runGC() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(GCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			//every call rewrites at most one file, it returns an error when there is nothing to clean
+			for s.db.RunValueLogGC(0.5) == nil {
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *BadgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = badgerGet(txn, key)
+		return err
+	})
+
+	return value, err
+}
+
+func (s *BadgerStore) Put(key, value []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (s *BadgerStore) Delete(key []byte) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (s *BadgerStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			//the key and the value are valid only inside the transaction
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(item.KeyCopy(nil), value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BadgerStore) Batch(fn func(batch Batch) error) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		return fn(badgerBatch{txn})
+	})
+}
+
+func (s *BadgerStore) BulkWrite(fn func(w Writer) error) error {
+	//a WriteBatch splits the writes in many transactions, so it has no size limit
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	if err := fn(badgerWriter{wb}); err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+func (s *BadgerStore) Close() error {
+	close(s.stop)
+	<-s.done
+
+	return s.db.Close()
+}
+
+// badgerBatch is a Batch inside a Badger transaction.
+type badgerBatch struct {
+	txn *badger.Txn
+}
+
+func (b badgerBatch) Get(key []byte) ([]byte, error) {
+	return badgerGet(b.txn, key)
+}
+
+func (b badgerBatch) Put(key, value []byte) error {
+	return b.txn.Set(key, value)
+}
+
+func (b badgerBatch) Delete(key []byte) error {
+	return b.txn.Delete(key)
+}
+
+// badgerWriter is a Writer of a Badger WriteBatch.
+type badgerWriter struct {
+	wb *badger.WriteBatch
+}
+
+func (w badgerWriter) Put(key, value []byte) error {
+	return w.wb.Set(key, value)
+}
+
+func (w badgerWriter) Delete(key []byte) error {
+	return w.wb.Delete(key)
+}
+
+// badgerGet read a copy of the value of key, the Badger error for a missing
+// key is converted into ErrKeyNotFound.
+func badgerGet(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return item.ValueCopy(nil)
+}
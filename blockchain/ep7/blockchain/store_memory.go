@@ -0,0 +1,165 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps the data inside a map, it is lost when
+// the process ends. It is used for tests and simulations.
+type MemoryStore struct {
+	data   map[string][]byte
+	closed bool
+	mu     sync.RWMutex
+}
+
+var errStoreClosed = errors.New("Store is closed")
+
+// NewMemoryStore create an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	value, ok := s.data[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (s *MemoryStore) Put(key, value []byte) error {
+	return s.Batch(func(batch Batch) error {
+		return batch.Put(key, value)
+	})
+}
+
+func (s *MemoryStore) Delete(key []byte) error {
+	return s.Batch(func(batch Batch) error {
+		return batch.Delete(key)
+	})
+}
+
+func (s *MemoryStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+
+	var keys []string
+	for key := range s.data {
+		if bytes.HasPrefix([]byte(key), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn([]byte(key), append([]byte{}, s.data[key]...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Batch(fn func(batch Batch) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+
+	batch := &memoryBatch{store: s, writes: make(map[string][]byte)}
+	if err := fn(batch); err != nil {
+		return err
+	}
+
+	for key, value := range batch.writes {
+		if value == nil {
+			delete(s.data, key)
+		} else {
+			s.data[key] = value
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) BulkWrite(fn func(w Writer) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errStoreClosed
+	}
+
+	//the writes go directly into the map, nothing to keep for a rollback
+	return fn(memoryWriter{s})
+}
+
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.data = nil
+	return nil
+}
+
+// memoryBatch keep the writes of a batch until the batch is saved, a nil
+// value is a deleted key.
+type memoryBatch struct {
+	store  *MemoryStore
+	writes map[string][]byte
+}
+
+func (b *memoryBatch) Get(key []byte) ([]byte, error) {
+	value, ok := b.writes[string(key)]
+	if !ok {
+		value, ok = b.store.data[string(key)]
+	}
+	if !ok || value == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	return append([]byte{}, value...), nil
+}
+
+func (b *memoryBatch) Put(key, value []byte) error {
+	//an empty value is saved as an empty slice, nil means deleted
+	b.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.writes[string(key)] = nil
+	return nil
+}
+
+// memoryWriter write directly into the map of the store of a BulkWrite.
+type memoryWriter struct {
+	store *MemoryStore
+}
+
+func (w memoryWriter) Put(key, value []byte) error {
+	w.store.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (w memoryWriter) Delete(key []byte) error {
+	delete(w.store.data, string(key))
+	return nil
+}
@@ -0,0 +1,30 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/RachidP/BlockChain/blockchain"
+	"github.com/RachidP/BlockChain/blockchain/storetest"
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestMemoryStore(t *testing.T) {
+	err := storetest.TestStore(func() (blockchain.Store, error) {
+		return blockchain.NewMemoryStore(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBadgerStore(t *testing.T) {
+	err := storetest.TestStore(func() (blockchain.Store, error) {
+		//every check uses a new empty db
+		dir := t.TempDir()
+		opts := badger.DefaultOptions(dir).WithLoggingLevel(badger.WARNING)
+		return blockchain.NewBadgerStore(opts)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,328 @@
+// Package storetest check that an implementation of blockchain.Store behaves
+// like the stores of the blockchain package, in the same way of testing/fstest.
+//
+// A test of a new store only needs:
+//
+//	if err := storetest.TestStore(func() (blockchain.Store, error) { return NewMyStore() }); err != nil {
+//		t.Fatal(err)
+//	}
+package storetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/RachidP/BlockChain/blockchain"
+)
+
+// TestStore run all the checks on the stores created by newStore, every check
+// uses a new empty store. It returns an error that lists all the checks that
+// failed, or nil if the store is correct.
+func TestStore(newStore func() (blockchain.Store, error)) error {
+	checks := []struct {
+		name  string
+		check func(s blockchain.Store) error
+	}{
+		{"GetMissing", checkGetMissing},
+		{"PutGet", checkPutGet},
+		{"Overwrite", checkOverwrite},
+		{"EmptyValue", checkEmptyValue},
+		{"ValueIsCopy", checkValueIsCopy},
+		{"Delete", checkDelete},
+		{"IteratePrefix", checkIteratePrefix},
+		{"IterateError", checkIterateError},
+		{"Batch", checkBatch},
+		{"BatchReadOwnWrites", checkBatchReadOwnWrites},
+		{"BatchRollback", checkBatchRollback},
+		{"BulkWrite", checkBulkWrite},
+	}
+
+	var failures []string
+	for _, c := range checks {
+		s, err := newStore()
+		if err != nil {
+			return fmt.Errorf("storetest: can't create the store: %w", err)
+		}
+
+		if err := c.check(s); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", c.name, err))
+		}
+		if err := s.Close(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: Close: %s", c.name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New("storetest: " + strings.Join(failures, "\n\t"))
+	}
+
+	return nil
+}
+
+// expect check that key has value inside the store.
+func expect(s blockchain.Store, key, value string) error {
+	v, err := s.Get([]byte(key))
+	if err != nil {
+		return fmt.Errorf("Get(%q): %s", key, err)
+	}
+	if string(v) != value {
+		return fmt.Errorf("Get(%q) = %q, want %q", key, v, value)
+	}
+
+	return nil
+}
+
+// expectMissing check that key is not inside the store.
+func expectMissing(s blockchain.Store, key string) error {
+	v, err := s.Get([]byte(key))
+	if err != blockchain.ErrKeyNotFound {
+		return fmt.Errorf("Get(%q) = %q, %v, want ErrKeyNotFound", key, v, err)
+	}
+
+	return nil
+}
+
+func checkGetMissing(s blockchain.Store) error {
+	return expectMissing(s, "missing")
+}
+
+func checkPutGet(s blockchain.Store) error {
+	if err := s.Put([]byte("key"), []byte("value")); err != nil {
+		return err
+	}
+
+	return expect(s, "key", "value")
+}
+
+func checkOverwrite(s blockchain.Store) error {
+	if err := s.Put([]byte("key"), []byte("first")); err != nil {
+		return err
+	}
+	if err := s.Put([]byte("key"), []byte("second")); err != nil {
+		return err
+	}
+
+	return expect(s, "key", "second")
+}
+
+func checkEmptyValue(s blockchain.Store) error {
+	if err := s.Put([]byte("key"), []byte{}); err != nil {
+		return err
+	}
+
+	return expect(s, "key", "")
+}
+
+func checkValueIsCopy(s blockchain.Store) error {
+	value := []byte("value")
+	if err := s.Put([]byte("key"), value); err != nil {
+		return err
+	}
+	//the store must not keep the slice of the caller
+	value[0] = 'X'
+
+	v, err := s.Get([]byte("key"))
+	if err != nil {
+		return err
+	}
+	//and the caller can change the slice returned by Get
+	v[0] = 'Y'
+
+	return expect(s, "key", "value")
+}
+
+func checkDelete(s blockchain.Store) error {
+	if err := s.Put([]byte("key"), []byte("value")); err != nil {
+		return err
+	}
+	if err := s.Delete([]byte("key")); err != nil {
+		return err
+	}
+	if err := expectMissing(s, "key"); err != nil {
+		return err
+	}
+
+	if err := s.Delete([]byte("missing")); err != nil {
+		return fmt.Errorf("Delete of a missing key: %s", err)
+	}
+
+	return nil
+}
+
+func checkIteratePrefix(s blockchain.Store) error {
+	data := map[string]string{
+		"a-2": "two",
+		"a-1": "one",
+		"a-3": "three",
+		"b-1": "other",
+		"a":   "short",
+	}
+	for k, v := range data {
+		if err := s.Put([]byte(k), []byte(v)); err != nil {
+			return err
+		}
+	}
+
+	var keys []string
+	err := s.Iterate([]byte("a-"), func(key, value []byte) error {
+		if data[string(key)] != string(value) {
+			return fmt.Errorf("key %q has value %q, want %q", key, value, data[string(key)])
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if got, want := strings.Join(keys, ","), "a-1,a-2,a-3"; got != want {
+		return fmt.Errorf("Iterate(%q) visited %s, want %s", "a-", got, want)
+	}
+
+	count := 0
+	err = s.Iterate(nil, func(key, value []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count != len(data) {
+		return fmt.Errorf("Iterate(nil) visited %d keys, want %d", count, len(data))
+	}
+
+	return nil
+}
+
+func checkIterateError(s blockchain.Store) error {
+	for _, k := range []string{"k-1", "k-2", "k-3"} {
+		if err := s.Put([]byte(k), []byte(k)); err != nil {
+			return err
+		}
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err := s.Iterate([]byte("k-"), func(key, value []byte) error {
+		visited++
+		return stop
+	})
+	if err != stop {
+		return fmt.Errorf("Iterate returned %v, want the error of fn", err)
+	}
+	if visited != 1 {
+		return fmt.Errorf("Iterate visited %d keys after the error, want 1", visited)
+	}
+
+	return nil
+}
+
+func checkBatch(s blockchain.Store) error {
+	if err := s.Put([]byte("old"), []byte("value")); err != nil {
+		return err
+	}
+
+	err := s.Batch(func(batch blockchain.Batch) error {
+		if err := batch.Put([]byte("new"), []byte("value")); err != nil {
+			return err
+		}
+		return batch.Delete([]byte("old"))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := expect(s, "new", "value"); err != nil {
+		return err
+	}
+
+	return expectMissing(s, "old")
+}
+
+func checkBatchReadOwnWrites(s blockchain.Store) error {
+	if err := s.Put([]byte("deleted"), []byte("value")); err != nil {
+		return err
+	}
+
+	return s.Batch(func(batch blockchain.Batch) error {
+		if err := batch.Put([]byte("key"), []byte("value")); err != nil {
+			return err
+		}
+		v, err := batch.Get([]byte("key"))
+		if err != nil || !bytes.Equal(v, []byte("value")) {
+			return fmt.Errorf("batch Get after Put = %q, %v", v, err)
+		}
+
+		if err := batch.Delete([]byte("deleted")); err != nil {
+			return err
+		}
+		if _, err := batch.Get([]byte("deleted")); err != blockchain.ErrKeyNotFound {
+			return fmt.Errorf("batch Get after Delete = %v, want ErrKeyNotFound", err)
+		}
+
+		return nil
+	})
+}
+
+func checkBatchRollback(s blockchain.Store) error {
+	if err := s.Put([]byte("old"), []byte("value")); err != nil {
+		return err
+	}
+
+	fail := errors.New("fail")
+	err := s.Batch(func(batch blockchain.Batch) error {
+		if err := batch.Put([]byte("new"), []byte("value")); err != nil {
+			return err
+		}
+		if err := batch.Delete([]byte("old")); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		return fmt.Errorf("Batch returned %v, want the error of fn", err)
+	}
+
+	//nothing of the failed batch is saved
+	if err := expectMissing(s, "new"); err != nil {
+		return err
+	}
+
+	return expect(s, "old", "value")
+}
+
+func checkBulkWrite(s blockchain.Store) error {
+	if err := s.Put([]byte("old"), []byte("value")); err != nil {
+		return err
+	}
+
+	//more keys than a Badger transaction can hold with small tables
+	const n = 20000
+	err := s.BulkWrite(func(w blockchain.Writer) error {
+		for i := 0; i < n; i++ {
+			if err := w.Put([]byte(fmt.Sprintf("k-%05d", i)), []byte("value")); err != nil {
+				return err
+			}
+		}
+		return w.Delete([]byte("old"))
+	})
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	err = s.Iterate([]byte("k-"), func(key, value []byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count != n {
+		return fmt.Errorf("Iterate found %d keys after BulkWrite, want %d", count, n)
+	}
+
+	return expectMissing(s, "old")
+}
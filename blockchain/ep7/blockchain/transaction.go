@@ -2,15 +2,12 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
-	"math/big"
 	"strings"
 
 	"github.com/RachidP/BlockChain/wallet"
@@ -18,48 +15,139 @@ import (
 
 // Transaction describe a transaction
 type Transaction struct {
-	ID      []byte //it's a Hash
-	Inputs  []TxInput
-	Outputs []TxOutput
+	ID       []byte //it's a Hash
+	Inputs   []TxInput
+	Outputs  []TxOutput
+	Version  int    //TxVersion, or an older version of the transactions created before it
+	LockTime uint32 //the transaction can't be inside a block before this height or time, 0 if it has no lock
 }
 
-// CoinbaseTx make the first transaction for the block Genesis
-func CoinbaseTx(to, data string) *Transaction {
+// The Version of the transactions: the ID of LegacyTxVersion is the hash of
+// the old gob encoding, BinaryTxVersion is the hash of the binary format,
+// from ScriptTxVersion the inputs and the outputs have their own scripts and
+// the transactions have the LockTime, and from SequenceTxVersion the inputs
+// have the Sequence. The older transactions are read with the P2PKH scripts of
+// their public keys, so they keep their ID and their signatures.
+const (
+	BinaryTxVersion   = 1
+	ScriptTxVersion   = 2
+	SequenceTxVersion = 3
+	TxVersion         = SequenceTxVersion //the Version of the new transactions
+)
+
+// LockTimeThreshold divide the LockTime: below it is a height, from it is a unix time.
+const LockTimeThreshold = 500000000
+
+// The Sequence of an input is its relative lock: the input can be inside a
+// block only when the output that it spends is old enough. The lower 16 bits
+// are the blocks after the block of the output, or with SequenceTypeFlag the
+// time after the time of that block in units of 512 seconds. 0 doesn't lock
+// the input, and the other bits are ignored like in Bitcoin.
+const (
+	SequenceTypeFlag    = 1 << 22
+	SequenceMask        = 0xffff
+	SequenceGranularity = 9 //the units of time are 1<<9 seconds
+)
+
+// TxLock is the absolute and the relative lock of a new transaction: the
+// LockTime of the transaction and the Sequence of all its inputs. The zero
+// TxLock doesn't lock the transaction.
+type TxLock struct {
+	LockTime uint32
+	Sequence uint32
+}
+
+// the reward of the miner start from InitialSubsidy and it is halved every
+// HalvingInterval blocks, they are variables so a test network can change them.
+var (
+	InitialSubsidy  = 100
+	HalvingInterval = 210
+)
+
+// BlockSubsidy return the new coins that the miner of the block at height can create.
+func BlockSubsidy(height int) int {
+	halvings := uint(height / HalvingInterval)
+	if halvings >= 63 {
+		return 0
+	}
+
+	return InitialSubsidy >> halvings
+}
+
+// CoinbaseTx make the transaction that pay the miner of the block at height,
+// the reward is the subsidy of the block plus the fees of the other transactions.
+func CoinbaseTx(to, data string, height, fees int) (*Transaction, error) {
 	if data == "" {
-		//make a new data
-		data = fmt.Sprintf("Coins to %s", to)
+		//make a random data, so every coinbase has a different ID
+		randData := make([]byte, 24)
+		if _, err := rand.Read(randData); err != nil {
+			return nil, err
+		}
+		data = fmt.Sprintf("%x", randData)
 	}
 
 	//define the Transaction input and output for this Coinbase
 	txin := TxInput{
-		ID:        []byte{}, //is nill because is referencing no output
-		Out:       -1,       //-1 because is referencing no output
-		Signature: nil,
-		PubKey:    []byte(data),
+		ID:  []byte{}, //is nill because is referencing no output
+		Out: -1,       //-1 because is referencing no output
+		//the ScriptSig of the coinbase is not run, it contains the data: the
+		//height at the start make every coinbase unique, after it there is the
+		//extra nonce changed by the miner
+		ScriptSig: append(append(ToHex(int64(height)), make([]byte, 8)...), []byte(data)...),
+	}
+	txout, err := NewTXOutput(BlockSubsidy(height)+fees, to)
+	if err != nil {
+		return nil, err
 	}
-	txout := NewTXOutput(100, to)
 
 	//create the transaction
 	tx := Transaction{ID: nil,
 		Inputs:  []TxInput{txin},
 		Outputs: []TxOutput{*txout},
+		Version: TxVersion,
 	}
 	tx.SetID()
-	return &tx
+	return &tx, nil
 
 }
 
-//SetId make the Hash  for the ID transaction
-func (tx *Transaction) SetID() {
+// CoinbaseHeight return the height written inside the coinbase transaction.
+func (tx *Transaction) CoinbaseHeight() (int, error) {
+	data := tx.coinbaseData()
+	if len(data) < 8 {
+		return 0, errors.New("Coinbase doesn't contain the height")
+	}
+
+	return int(binary.BigEndian.Uint64(data[:8])), nil
+}
 
-	var encoded bytes.Buffer
-	var hash [32]byte
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	HandleErr(err)
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+// coinbaseData return the data of the coinbase, the old coinbases had it in
+// place of the public key.
+func (tx *Transaction) coinbaseData() []byte {
+	if tx.Version < ScriptTxVersion {
+		_, data := sigScriptParts(tx.Inputs[0].ScriptSig)
+		return data
+	}
+
+	return tx.Inputs[0].ScriptSig
+}
 
+// SetExtraNonce write extraNonce inside the data of the coinbase, after the
+// height, and update the ID. The miner changes it when all the nonces of the
+// block have been tried.
+func (tx *Transaction) SetExtraNonce(extraNonce uint64) error {
+	if !tx.IsCoinbase() || tx.Version < ScriptTxVersion || len(tx.Inputs[0].ScriptSig) < 16 {
+		return errors.New("Transaction doesn't have an extra nonce")
+	}
+
+	binary.BigEndian.PutUint64(tx.Inputs[0].ScriptSig[8:16], extraNonce)
+	tx.SetID()
+	return nil
+}
+
+//SetId make the Hash  for the ID transaction
+func (tx *Transaction) SetID() {
+	tx.ID = tx.Hash()
 }
 
 //IsCoinBase determine whether of not a transaction is a coinbase transaction.
@@ -68,167 +156,359 @@ func (tx *Transaction) IsCoinBase() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-//NewTransaction
-func NewTransaction(from, to string, amount int, UTXO *UTXOSet) *Transaction {
+//NewTransaction create a transaction that send amount to the address to and
+//pay fee to the miner, the fee is the value of the inputs that is not spent by the outputs.
+//It fails with ErrInsufficientFunds if the wallet can't pay amount and fee.
+func NewTransaction(w *wallet.Wallet, to string, amount, fee int, lock TxLock, UTXO *UTXOSet) (*Transaction, error) {
+	from := fmt.Sprintf("%s", w.Address())
+	tx, err := NewUnsignedTransaction(from, to, amount, fee, lock, UTXO)
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{from: w}}
+	if err := UTXO.Blockchain.SignTransaction(tx, ws); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+//NewUnsignedTransaction create the transaction of NewTransaction that spends
+//the outputs of the address from, a wallet or a script. The inputs are not
+//signed: every owner of from signs it with Sign.
+func NewUnsignedTransaction(from, to string, amount, fee int, lock TxLock, UTXO *UTXOSet) (*Transaction, error) {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	wallets, err := wallet.CreateWallets()
-	HandleErr(err)
-	w := wallets.GetWallet(from)
-	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	script, err := AddressScript(from)
+	if err != nil {
+		return nil, err
+	}
 
-	acc, validOutputs := UTXO.FindSpendableOutputs(pubKeyHash, amount)
+	//the inputs must cover the amount and the fee
+	acc, validOutputs, err := UTXO.FindSpendableOutputs(script, amount+fee)
+	if err != nil {
+		return nil, err
+	}
 
-	if acc < amount {
-		log.Panic("Error: not enough funds")
+	if acc < amount+fee {
+		return nil, fmt.Errorf("%w: %d available, %d needed", ErrInsufficientFunds, acc, amount+fee)
 	}
 
 	for txid, outs := range validOutputs {
 		txID, err := hex.DecodeString(txid)
-		HandleErr(err)
+		if err != nil {
+			return nil, err
+		}
 		//create a input for each unspent output
 		for _, out := range outs {
 
-			input := TxInput{txID, out, nil, w.PublicKey}
+			input := TxInput{ID: txID, Out: out, Sequence: lock.Sequence}
 
 			inputs = append(inputs, input)
 		}
 	}
 
 	//create the output for the transaction
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	out, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, *out)
 
 	//the ammount from that the user has is  greater than the user is trying to send
-	if acc > amount {
+	if acc > amount+fee {
 		//create a second output
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+		change, err := NewTXOutput(acc-amount-fee, from)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
 	}
 
-	tx := Transaction{nil, inputs, outputs}
+	tx := Transaction{Inputs: inputs, Outputs: outputs, Version: TxVersion, LockTime: lock.LockTime}
 	tx.ID = tx.Hash()
-	UTXO.Blockchain.SignTransaction(&tx, w.PrivateKey)
 
-	return &tx
+	return &tx, nil
 }
 
-//Serialize serialize a transaction into []byte
+//NewTransactionWithFeeRate create a transaction like NewTransaction but the fee
+//is feeRate for every byte of the serialized transaction.
+func NewTransactionWithFeeRate(w *wallet.Wallet, to string, amount, feeRate int, lock TxLock, UTXO *UTXOSet) (*Transaction, error) {
+	fee := 0
+	for {
+		tx, err := NewTransaction(w, to, amount, fee, lock, UTXO)
+		if err != nil {
+			return nil, err
+		}
+
+		//the size depends on the inputs, and the inputs depend on the fee,
+		//so we try again until the fee is enough for the size
+		required := feeRate * len(tx.Serialize())
+		if fee >= required {
+			return tx, nil
+		}
+		fee = required
+	}
+}
+
+//Serialize serialize a transaction into []byte with the binary format of encoding.go
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	e := newEncoder()
+	e.transaction(&tx)
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+	return e.buf.Bytes()
+}
+
+// DeserializeTransaction decode the data from []bytes to a Transaction
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	d := newDecoder(data)
+	tx := d.transaction()
+	if err := d.finish(); err != nil {
+		return Transaction{}, err
 	}
 
-	return encoded.Bytes()
+	return *tx, nil
 }
 
-//Hash take a transaction and make a Hash that can be used as a transaction id.
+//Hash take a transaction and make a Hash that can be used as a transaction id:
+//the sha256 of the transaction serialized without the ID and the ScriptSig of
+//the inputs, so the ID doesn't change when the transaction is signed. The data
+//of the coinbase is part of the ID, and the old versions remove only the
+//signature and keep the public key.
 func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-
 	txCopy := *tx
-	txCopy.ID = []byte{}
+	txCopy.ID = nil
+	txCopy.Inputs = nil
+	coinbase := tx.IsCoinbase()
+	for _, in := range tx.Inputs {
+		switch {
+		case coinbase:
+		case tx.Version < ScriptTxVersion:
+			_, pubKey := sigScriptParts(in.ScriptSig)
+			in.ScriptSig = sigScript(nil, pubKey)
+		default:
+			in.ScriptSig = nil
+		}
+		txCopy.Inputs = append(txCopy.Inputs, in)
+	}
+
+	if tx.Version == LegacyTxVersion {
+		return legacyTxHash(&txCopy)
+	}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash := sha256.Sum256(txCopy.Serialize())
 
 	return hash[:]
 }
 
-//Sign sign and verify a transaction.
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) {
+//FullHash return the sha256 of the whole transaction with the ScriptSigs of
+//the inputs, unlike the ID it changes when a signature is changed.
+func (tx *Transaction) FullHash() []byte {
+	hash := sha256.Sum256(tx.Serialize())
+
+	return hash[:]
+}
+
+//signatureHash return the hash signed by the input at index, script is the
+//ScriptPubKey of the output that it spends. It's the hash of the transaction
+//with the script in place of the ScriptSig of the input and without the
+//ScriptSig of the other inputs.
+func (tx *Transaction) signatureHash(index int, script []byte) []byte {
+	txCopy := tx.TrimmedCopy()
+	txCopy.ID = nil
+
+	if tx.Version < ScriptTxVersion {
+		//the old versions signed the ID with the public key hash in place of the public key
+		pubKeyHash, _ := P2PKHPubKeyHash(script)
+		txCopy.Inputs[index].ScriptSig = sigScript(nil, pubKeyHash)
+		return txCopy.Hash()
+	}
+
+	txCopy.Inputs[index].ScriptSig = script
+	hash := sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+//Sign sign the inputs that the wallets can sign: the P2PKH outputs of their
+//keys and the P2SH outputs of the multisig scripts with their keys. The other
+//inputs are not changed, so the owners of a multisig address can sign the
+//transaction one after the other.
+func (tx *Transaction) Sign(ws *wallet.Wallets, prevTXs map[string]Transaction) error {
 
 	// if the transaction is a coinbase we don't have to sign it
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
+	prevOutputs, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		return err
+	}
+
+	for inId, prevOut := range prevOutputs {
+		if pubKeyHash, ok := P2PKHPubKeyHash(prevOut.ScriptPubKey); ok {
+			err = tx.signP2PKH(inId, prevOut.ScriptPubKey, pubKeyHash, ws)
+		} else if scriptHash, ok := P2SHScriptHash(prevOut.ScriptPubKey); ok {
+			err = tx.signMultisig(inId, scriptHash, ws)
+		}
+		if err != nil {
+			return err
 		}
 	}
-	//get a copy of the transaction
-	txCopy := tx.TrimmedCopy()
 
-	for inId, in := range txCopy.Inputs {
-		prevTX := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTX.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
+	return nil
+}
+
+//signP2PKH sign the input at index if the key of pubKeyHash is inside the wallets.
+func (tx *Transaction) signP2PKH(index int, script, pubKeyHash []byte, ws *wallet.Wallets) error {
+	w, ok := ws.WalletOf(pubKeyHash)
+	if !ok {
+		return nil
+	}
+
+	signature, err := wallet.Sign(&w.PrivateKey, tx.signatureHash(index, script))
+	if err != nil {
+		return err
+	}
+	tx.Inputs[index].ScriptSig = sigScript(signature, w.PublicKey)
+
+	return nil
+}
+
+//signMultisig add the signatures of the wallets to the input at index, that
+//spends the multisig script with the hash scriptHash. The signatures already
+//inside the ScriptSig are kept, and the script is taken from the ScriptSig or
+//from the wallets.
+func (tx *Transaction) signMultisig(index int, scriptHash []byte, ws *wallet.Wallets) error {
+	//a partial ScriptSig is <signature> ... <redeem script>
+	var signatures [][]byte
+	var redeemScript []byte
+	if ins, err := parseScript(tx.Inputs[index].ScriptSig); err == nil && len(ins) > 0 {
+		redeemScript = ins[len(ins)-1].data
+		for _, in := range ins[:len(ins)-1] {
+			signatures = append(signatures, in.data)
+		}
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(redeemScript), scriptHash) {
+		var ok bool
+		if redeemScript, ok = ws.ScriptOf(scriptHash); !ok {
+			return nil
+		}
+		signatures = nil
+	}
+	required, pubKeys, ok := ParseMultisigScript(redeemScript)
+	if !ok {
+		return nil
+	}
+
+	//the signatures must be in the order of the keys
+	hash := tx.signatureHash(index, redeemScript)
+	byKey := make([][]byte, len(pubKeys))
+	for _, signature := range signatures {
+		for k, pubKey := range pubKeys {
+			if byKey[k] == nil && wallet.Verify(pubKey, hash, signature) {
+				byKey[k] = signature
+				break
+			}
+		}
+	}
+	for k, pubKey := range pubKeys {
+		w, ok := ws.WalletOf(wallet.PublicKeyHash(pubKey))
+		if byKey[k] != nil || !ok {
+			continue
+		}
+		signature, err := wallet.Sign(&w.PrivateKey, hash)
+		if err != nil {
+			return err
+		}
+		byKey[k] = signature
+	}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, txCopy.ID)
-		HandleErr(err)
-		signature := append(r.Bytes(), s.Bytes()...)
+	var scriptSig []byte
+	count := 0
+	for _, signature := range byKey {
+		if signature != nil && count < required {
+			scriptSig = pushData(scriptSig, signature)
+			count++
+		}
+	}
+	tx.Inputs[index].ScriptSig = pushData(scriptSig, redeemScript)
 
-		tx.Inputs[inId].Signature = signature
+	return nil
+}
 
+//prevOutputs return the outputs spent by the inputs, prevTXs must contain
+//their transactions.
+func (tx *Transaction) prevOutputs(prevTXs map[string]Transaction) ([]TxOutput, error) {
+	var outputs []TxOutput
+	for _, in := range tx.Inputs {
+		prevTx, ok := prevTXs[hex.EncodeToString(in.ID)]
+		if !ok || prevTx.ID == nil {
+			return nil, fmt.Errorf("%w: %x", ErrTxNotFound, in.ID)
+		}
+		if in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return nil, fmt.Errorf("Output %x:%d doesn't exist", in.ID, in.Out)
+		}
+		outputs = append(outputs, prevTx.Outputs[in.Out])
 	}
+
+	return outputs, nil
 }
 
-//TrimmedCopy get a copy of the transaction
+//TrimmedCopy get a copy of the transaction without the ScriptSig of the inputs
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
 	for _, in := range tx.Inputs {
-		inputs = append(inputs, TxInput{in.ID, in.Out, nil, nil})
+		inputs = append(inputs, TxInput{ID: in.ID, Out: in.Out, Sequence: in.Sequence})
 	}
 
 	for _, out := range tx.Outputs {
-		outputs = append(outputs, TxOutput{out.Value, out.PubKeyHash})
+		outputs = append(outputs, TxOutput{out.Value, out.ScriptPubKey})
 	}
 
-	txCopy := Transaction{tx.ID, inputs, outputs}
+	txCopy := Transaction{tx.ID, inputs, outputs, tx.Version, tx.LockTime}
 
 	return txCopy
 }
 
-//Verify verify all the transactions.
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+//Verify run the scripts of all the inputs, the error is ErrScriptFailed if an
+//input can't spend his output.
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction not correct")
-		}
+	//a transaction that spends an unknown output is not valid
+	prevOutputs, err := tx.prevOutputs(prevTXs)
+	if err != nil {
+		return err
 	}
 
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
-	for inId, in := range tx.Inputs {
-		prevTx := prevTXs[hex.EncodeToString(in.ID)]
-		txCopy.Inputs[inId].Signature = nil
-		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
-		txCopy.Inputs[inId].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-
-		sigLen := len(in.Signature)
-		r.SetBytes(in.Signature[:(sigLen / 2)])
-		s.SetBytes(in.Signature[(sigLen / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(in.PubKey)
-		x.SetBytes(in.PubKey[:(keyLen / 2)])
-		y.SetBytes(in.PubKey[(keyLen / 2):])
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) == false {
-			return false
+	for inId := range tx.Inputs {
+		if err := tx.verifyInput(inId, prevOutputs[inId].ScriptPubKey); err != nil {
+			return fmt.Errorf("Input %d: %w", inId, err)
 		}
 	}
 
-	return true
+	return nil
+}
+
+//IsFinal check if the transaction can be inside the block at height with the
+//time blockTime, the LockTime must be before them.
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return int64(tx.LockTime) < int64(height)
+	}
+
+	return int64(tx.LockTime) < blockTime
 }
 
 //String convert a transaction into string rappresentation.
@@ -236,18 +516,27 @@ func (tx Transaction) String() string {
 	var lines []string
 
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
+	}
 	for i, input := range tx.Inputs {
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:     %x", input.ID))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Out))
-		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
-		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+		if tx.IsCoinbase() {
+			lines = append(lines, fmt.Sprintf("       Data:      %x", tx.coinbaseData()))
+		} else {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", ScriptString(input.ScriptSig)))
+		}
+		if input.Sequence != 0 {
+			lines = append(lines, fmt.Sprintf("       Sequence:  %08x", input.Sequence))
+		}
 	}
 
 	for i, output := range tx.Outputs {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
-		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+		lines = append(lines, fmt.Sprintf("       Script: %s", ScriptString(output.ScriptPubKey)))
 	}
 
 	return strings.Join(lines, "\n")
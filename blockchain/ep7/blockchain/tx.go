@@ -2,73 +2,112 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
 
 	"github.com/RachidP/BlockChain/wallet"
 )
 
 type TxOutput struct {
-	Value      int    //the values are the amount of tokens inside of it
-	PubKeyHash []byte // is the public key for unlock the token (inside the Value)
+	Value        int    //the values are the amount of tokens inside of it
+	ScriptPubKey []byte //the script that the input must satisfy to unlock the token (inside the Value)
 }
 
-//TxOutputs identify transactions outputs, and sort them by unspent output
+//TxOutputs identify transactions outputs, and sort them by unspent output.
+//The key of the map is the index of the output inside his transaction, so the
+//index doesn't change when the other outputs are spent.
 type TxOutputs struct {
-	Outputs []TxOutput
+	Outputs map[int]TxOutput
 }
 
 //TxInput is a references to previous outputs
 type TxInput struct {
 	ID        []byte //reference the transaction that the output is inside
 	Out       int    //index of the output appears (ID)
-	Signature []byte
-	PubKey    []byte
+	ScriptSig []byte //the script that unlock the output, e.g. the signature and the public key
+	Sequence  uint32 //relative lock of the input, see SequenceTypeFlag
 }
 
-//UsesKey
+//UsesKey check if the input is signed by the key of pubkeyHash with a P2PKH ScriptSig.
 func (in *TxInput) UsesKey(pubkeyHash []byte) bool {
-	lockingHash := wallet.PublicKeyHash(in.PubKey)
+	_, pubKey := sigScriptParts(in.ScriptSig)
+	lockingHash := wallet.PublicKeyHash(pubKey)
 	return bytes.Compare(lockingHash, pubkeyHash) == 0
 }
 
-//Lock lock the output.
-func (out *TxOutput) Lock(address []byte) {
-	pubKeyHash := wallet.Base58Decode(address)
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	out.PubKeyHash = pubKeyHash
+//Lock lock the output to the address: P2PKH for the address of a wallet,
+//P2SH for the address of a script.
+func (out *TxOutput) Lock(address []byte) error {
+	script, err := AddressScript(string(address))
+	if err != nil {
+		return err
+	}
+	out.ScriptPubKey = script
+	return nil
 }
 
-//IsLockedWithKey check if the output is locked.
+//AddressScript return the ScriptPubKey that locks an output to the address.
+func AddressScript(address string) ([]byte, error) {
+	hash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		return nil, err
+	}
+	if wallet.IsScriptAddress(address) {
+		return P2SHScript(hash), nil
+	}
+
+	return P2PKHScript(hash), nil
+}
+
+//IsLockedWithKey check if the output is locked to pubKeyHash with a P2PKH script.
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Compare(out.PubKeyHash, pubKeyHash) == 0
+	outHash, ok := P2PKHPubKeyHash(out.ScriptPubKey)
+	return ok && bytes.Compare(outHash, pubKeyHash) == 0
+}
+
+//AddressHash return the hash inside the address of the output: the public key
+//hash of a P2PKH output, the script hash of a P2SH output, nil for the other scripts.
+func (out *TxOutput) AddressHash() []byte {
+	if pubKeyHash, ok := P2PKHPubKeyHash(out.ScriptPubKey); ok {
+		return pubKeyHash
+	}
+	if scriptHash, ok := P2SHScriptHash(out.ScriptPubKey); ok {
+		return scriptHash
+	}
+	return nil
+}
+
+//Address return the address of a P2PKH or P2SH output, an empty string for the other scripts.
+func (out *TxOutput) Address() string {
+	if pubKeyHash, ok := P2PKHPubKeyHash(out.ScriptPubKey); ok {
+		return string(wallet.PubKeyHashAddress(pubKeyHash))
+	}
+	if scriptHash, ok := P2SHScriptHash(out.ScriptPubKey); ok {
+		return string(wallet.ScriptHashAddress(scriptHash))
+	}
+	return ""
 }
 
 //NewTXOutput
-func NewTXOutput(value int, address string) *TxOutput {
+func NewTXOutput(value int, address string) (*TxOutput, error) {
 	txo := &TxOutput{value, nil}
-	txo.Lock([]byte(address))
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
 
-	return txo
+	return txo, nil
 }
 
 //Serialize encode the strture TxOutputs into []byte
 func (outs TxOutputs) Serialize() []byte {
-	var buffer bytes.Buffer
+	e := newEncoder()
+	e.outputs(outs)
 
-	encode := gob.NewEncoder(&buffer)
-	err := encode.Encode(outs)
-	HandleErr(err)
-
-	return buffer.Bytes()
+	return e.buf.Bytes()
 }
 
 //DeserializeOutputs decode the byte into structure TxOutputs
-func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
-
-	decode := gob.NewDecoder(bytes.NewReader(data))
-	err := decode.Decode(&outputs)
-	HandleErr(err)
+func DeserializeOutputs(data []byte) (TxOutputs, error) {
+	d := newDecoder(data)
+	outputs := d.outputs()
 
-	return outputs
+	return outputs, d.finish()
 }
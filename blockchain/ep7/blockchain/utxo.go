@@ -3,16 +3,44 @@ package blockchain
 import (
 	"bytes"
 	"encoding/hex"
-	"log"
-
-	"github.com/dgraph-io/badger"
+	"fmt"
 )
 
 var (
-	utxoPrefix   = []byte("utxo-") //is a prefix for the keys in the DB for separate data i(nto badger DB) because badger doesn't have tables
+	utxoPrefix   = []byte("utxo-") //is a prefix for the keys in the DB for separate data i(nto the Store) because the stores don't have tables
+	undoPrefix   = []byte("undo-") //prefix of the outputs spent by a block, used to disconnect the block
 	prefixLength = len(utxoPrefix)
 )
 
+//SpentOutput is an output spent by a block, with the position where it was.
+type SpentOutput struct {
+	TxID   []byte
+	Index  int
+	Output TxOutput
+}
+
+//BlockUndo contain the outputs spent by a block, so we can put them back
+//inside the UTXOSet when the block is disconnected.
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+//Serialize encode the BlockUndo into []byte
+func (undo BlockUndo) Serialize() []byte {
+	e := newEncoder()
+	e.undo(undo)
+
+	return e.buf.Bytes()
+}
+
+//DeserializeUndo decode the []byte into a BlockUndo
+func DeserializeUndo(data []byte) (BlockUndo, error) {
+	d := newDecoder(data)
+	undo := d.undo()
+
+	return undo, d.finish()
+}
+
 //UTXOSet is the main structure for the unspent transaction outputs
 type UTXOSet struct {
 	Blockchain *BlockChain
@@ -20,103 +48,155 @@ type UTXOSet struct {
 
 //FindUTXO FindUnspentTransactions
 //Unspent transactions are transactions that have output wich are not referenced by other inputs
-//that's means that are tokens still exist for a certain user
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TxOutput {
+//that's means that are tokens still exist for a certain user, the outputs are
+//locked with script (see AddressScript)
+func (u UTXOSet) FindUTXO(script []byte) ([]TxOutput, error) {
 	var UTXOs []TxOutput
 
 	db := u.Blockchain.Database
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := db.Iterate(utxoPrefix, func(k, v []byte) error {
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return err
+		}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
+		for _, out := range outs.Outputs {
+			if bytes.Equal(out.ScriptPubKey, script) {
+				UTXOs = append(UTXOs, out)
+			}
+		}
 
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			v, err := item.Value()
-			HandleErr(err)
-			outs := DeserializeOutputs(v)
+		return nil
+	})
 
-			for _, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubKeyHash) {
-					UTXOs = append(UTXOs, out)
-				}
+	return UTXOs, err
+}
+
+// Balances return the balance of every address with unspent outputs, only
+// the P2PKH and P2SH outputs have an address.
+func (u UTXOSet) Balances() (map[string]int, error) {
+	balances := make(map[string]int)
+
+	err := u.Blockchain.Database.Iterate(utxoPrefix, func(k, v []byte) error {
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return err
+		}
+
+		for _, out := range outs.Outputs {
+			if address := out.Address(); address != "" {
+				balances[address] += out.Value
 			}
 		}
 
 		return nil
 	})
-	HandleErr(err)
 
-	return UTXOs
+	return balances, err
 }
 
-// FindSpendableOutputs create and send transactions inside the blockchain.
-func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+// FindSpendableOutputs find the outputs locked with script that are enough to
+// spend amount, the amount must include the fee of the transaction.
+func (u UTXOSet) FindSpendableOutputs(script []byte, amount int) (int, map[string][]int, error) {
 	unspentOuts := make(map[string][]int)
 	accumulated := 0
 	db := u.Blockchain.Database
 	//iterate over the db
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			item := it.Item()
-			k := item.Key()        //get the key inside the DB
-			v, err := item.Value() // get the value from the db
-			HandleErr(err)
-			k = bytes.TrimPrefix(k, utxoPrefix) //trim the prefix from the key
-			txID := hex.EncodeToString(k)       //encode the key into a string
-			outs := DeserializeOutputs(v)       //deserialize it into output struct
-			//iterate over the outputs
-			for outIdx, out := range outs.Outputs {
-				//check if the output has been looked with the pubKeyHash
-				if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOuts[txID] = append(unspentOuts[txID], outIdx)
-				}
+	err := db.Iterate(utxoPrefix, func(k, v []byte) error {
+		k = bytes.TrimPrefix(k, utxoPrefix) //trim the prefix from the key
+		txID := hex.EncodeToString(k)       //encode the key into a string
+		outs, err := DeserializeOutputs(v)  //deserialize it into output struct
+		if err != nil {
+			return err
+		}
+		//iterate over the outputs
+		for outIdx, out := range outs.Outputs {
+			//check if the output has been looked with the script
+			if bytes.Equal(out.ScriptPubKey, script) && accumulated < amount {
+				accumulated += out.Value
+				unspentOuts[txID] = append(unspentOuts[txID], outIdx)
 			}
 		}
 		return nil
 	})
-	HandleErr(err)
-	return accumulated, unspentOuts
+	return accumulated, unspentOuts, err
 }
 
-func (u UTXOSet) CountTransactions() int {
-	db := u.Blockchain.Database
-	counter := 0
+// FindOutput return the unspent output with index outIdx of the transaction txID,
+// the bool is false if the output doesn't exist or it is already spent.
+func (u UTXOSet) FindOutput(txID []byte, outIdx int) (TxOutput, bool, error) {
+	v, err := u.Blockchain.Database.Get(append(utxoPrefix, txID...))
+	if err == ErrKeyNotFound {
+		return TxOutput{}, false, nil
+	}
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+
+	outs, err := DeserializeOutputs(v)
+	if err != nil {
+		return TxOutput{}, false, err
+	}
+	output, found := outs.Outputs[outIdx]
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	return output, found, nil
+}
 
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Seek(utxoPrefix); it.ValidForPrefix(utxoPrefix); it.Next() {
-			counter++
+// TransactionFee return the fee of the transaction using the values of the
+// unspent outputs, it fails with ErrInvalidTx if an input is not inside the
+// UTXOSet, if an output is negative or if the sums overflow. The fee is
+// negative if the outputs are greater than the inputs.
+func (u UTXOSet) TransactionFee(tx *Transaction) (int, error) {
+	inputs := 0
+	for _, in := range tx.Inputs {
+		out, ok, err := u.FindOutput(in.ID, in.Out)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("%w: input %x:%d is not in the UTXO set", ErrInvalidTx, in.ID, in.Out)
 		}
+		if inputs, err = addValue(inputs, out.Value); err != nil {
+			return 0, fmt.Errorf("%w: input %x:%d: %s", ErrInvalidTx, in.ID, in.Out, err)
+		}
+	}
+	outputs, err := tx.OutputValue()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTx, err)
+	}
+
+	return inputs - outputs, nil
+}
 
+// CountTransactions return the number of transactions with unspent outputs.
+func (u UTXOSet) CountTransactions() (int, error) {
+	db := u.Blockchain.Database
+	counter := 0
+
+	err := db.Iterate(utxoPrefix, func(k, v []byte) error {
+		counter++
 		return nil
 	})
 
-	HandleErr(err)
-
-	return counter
+	return counter, err
 }
 
-//Reindex
-func (u UTXOSet) Reindex() {
+//Reindex rebuild the UTXOSet from the blocks of the chain.
+func (u UTXOSet) Reindex() error {
 	db := u.Blockchain.Database //allias  the db
 
-	u.DeleteByPrefix(utxoPrefix)
+	if err := u.DeleteByPrefix(utxoPrefix); err != nil {
+		return err
+	}
 
-	UTXO := u.Blockchain.FindUTXO()
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
 
-	err := db.Update(func(txn *badger.Txn) error {
+	//the whole UTXO set can be too big for one transaction
+	return db.BulkWrite(func(w Writer) error {
 		for txId, outs := range UTXO {
 			key, err := hex.DecodeString(txId)
 			if err != nil {
@@ -124,114 +204,195 @@ func (u UTXOSet) Reindex() {
 			}
 			key = append(utxoPrefix, key...)
 			//push into db
-			err = txn.Set(key, outs.Serialize())
-			HandleErr(err)
+			if err := w.Put(key, outs.Serialize()); err != nil {
+				return err
+			}
 		}
 
 		return nil
 	})
-	HandleErr(err)
 }
 
 //Update the UTXOset inside the db by taken the block.
-func (u *UTXOSet) Update(block *Block) {
-	db := u.Blockchain.Database
+//The spent outputs are saved as undo data of the block, and the block is
+//added to the indexes of the main chain (and to the address index if it is
+//enabled) in the same batch.
+func (u *UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Database.Batch(func(batch Batch) error {
+		return u.update(batch, block)
+	})
+}
 
-	err := db.Update(func(txn *badger.Txn) error {
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, in := range tx.Inputs {
-					updatedOuts := TxOutputs{}
-					inID := append(utxoPrefix, in.ID...)
-					item, err := txn.Get(inID)
-					HandleErr(err)
-					v, err := item.Value()
-					HandleErr(err)
-
-					outs := DeserializeOutputs(v)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != in.Out {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+//update is Update inside batch.
+func (u *UTXOSet) update(batch Batch, block *Block) error {
+	undo := BlockUndo{}
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, in := range tx.Inputs {
+				updatedOuts := TxOutputs{Outputs: make(map[int]TxOutput)}
+				inID := append(utxoPrefix, in.ID...)
+				v, err := batch.Get(inID)
+				if err != nil {
+					return err
+				}
 
-					if len(updatedOuts.Outputs) == 0 {
-						if err := txn.Delete(inID); err != nil {
-							log.Panic(err)
-						}
+				outs, err := DeserializeOutputs(v)
+				if err != nil {
+					return err
+				}
 
+				for outIdx, out := range outs.Outputs {
+					if outIdx != in.Out {
+						updatedOuts.Outputs[outIdx] = out
 					} else {
-						if err := txn.Set(inID, updatedOuts.Serialize()); err != nil {
-							log.Panic(err)
-						}
+						undo.Spent = append(undo.Spent, SpentOutput{in.ID, outIdx, out})
 					}
 				}
-			}
 
-			newOutputs := TxOutputs{}
-			for _, out := range tx.Outputs {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
+				if len(updatedOuts.Outputs) == 0 {
+					if err := batch.Delete(inID); err != nil {
+						return err
+					}
 
-			txID := append(utxoPrefix, tx.ID...)
-			if err := txn.Set(txID, newOutputs.Serialize()); err != nil {
-				log.Panic(err)
+				} else {
+					if err := batch.Put(inID, updatedOuts.Serialize()); err != nil {
+						return err
+					}
+				}
 			}
 		}
 
-		return nil
+		newOutputs := TxOutputs{Outputs: make(map[int]TxOutput)}
+		for outIdx, out := range tx.Outputs {
+			newOutputs.Outputs[outIdx] = out
+		}
+
+		txID := append(utxoPrefix, tx.ID...)
+		if err := batch.Put(txID, newOutputs.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	if err := indexBlock(batch, block); err != nil {
+		return err
+	}
+	if err := u.updateAddresses(batch, block, undo, putAddressTxs); err != nil {
+		return err
+	}
+
+	return batch.Put(append(undoPrefix, block.Hash...), undo.Serialize())
+}
+
+//HasUndo check if there are the undo data to disconnect the block.
+func (u *UTXOSet) HasUndo(block *Block) (bool, error) {
+	_, err := u.Blockchain.Database.Get(append(undoPrefix, block.Hash...))
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+//Disconnect is the opposite of Update: it remove the outputs created by the
+//block and put back the outputs spent by the block, using the undo data.
+//The block is also removed from the indexes of the main chain and from the
+//address index.
+func (u *UTXOSet) Disconnect(block *Block) error {
+	return u.Blockchain.Database.Batch(func(batch Batch) error {
+		return u.disconnect(batch, block)
 	})
-	HandleErr(err)
 }
 
-//DeleteByPrefix go throw the DB and delete in bulk the prefix keys from the DB.
-func (u *UTXOSet) DeleteByPrefix(prefix []byte) {
-	//access to the db and delete the key if no errors
-	deleteKeys := func(keysForDelete [][]byte) error {
-		if err := u.Blockchain.Database.Update(func(txn *badger.Txn) error {
-			for _, key := range keysForDelete {
-				if err := txn.Delete(key); err != nil {
-					return err
-				}
-			}
-			return nil
-		}); err != nil {
+//disconnect is Disconnect inside batch.
+func (u *UTXOSet) disconnect(batch Batch, block *Block) error {
+	undoKey := append(undoPrefix, block.Hash...)
+	v, err := batch.Get(undoKey)
+	if err == ErrKeyNotFound {
+		return fmt.Errorf("No undo data for block %x", block.Hash)
+	}
+	if err != nil {
+		return err
+	}
+	undo, err := DeserializeUndo(v)
+	if err != nil {
+		return err
+	}
+
+	//the outputs of the block are unspent, because the blocks after it
+	//are already disconnected
+	for _, tx := range block.Transactions {
+		if err := batch.Delete(append(utxoPrefix, tx.ID...)); err != nil {
 			return err
 		}
-		return nil
 	}
-	//set the optimal ammout of keys that can be deleted in one batch delete with badger DB
-	collectSize := 100000
-
-	u.Blockchain.Database.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = false
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		keysForDelete := make([][]byte, 0, collectSize) //collect the keys that they will be deleted
-		keysCollected := 0
-
-		//iterate over all the keys that contain the "prefix"
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			key := it.Item().KeyCopy(nil)              //copy the key itself
-			keysForDelete = append(keysForDelete, key) //add the key to delete into keysForDelete
-			keysCollected++
-			//if the the number the keys to delete is 100000 we can delete all theese keys
-			if keysCollected == collectSize {
-				if err := deleteKeys(keysForDelete); err != nil {
-					log.Panic(err)
-				}
-				//reset the data for the next for cycle
-				keysForDelete = make([][]byte, 0, collectSize)
-				keysCollected = 0
+
+	for i := len(undo.Spent) - 1; i >= 0; i-- {
+		spent := undo.Spent[i]
+		outs := TxOutputs{Outputs: make(map[int]TxOutput)}
+
+		key := append(utxoPrefix, spent.TxID...)
+		v, err := batch.Get(key)
+		if err == nil {
+			if outs, err = DeserializeOutputs(v); err != nil {
+				return err
 			}
+		} else if err != ErrKeyNotFound {
+			return err
+		}
+
+		outs.Outputs[spent.Index] = spent.Output
+		if err := batch.Put(key, outs.Serialize()); err != nil {
+			return err
 		}
-		// if the are others key to delete that are less than 10000 keys, delete them
-		if keysCollected > 0 {
-			if err := deleteKeys(keysForDelete); err != nil {
-				log.Panic(err)
+	}
+
+	if err := unindexBlock(batch, block); err != nil {
+		return err
+	}
+	if err := u.updateAddresses(batch, block, undo, deleteAddressTxs); err != nil {
+		return err
+	}
+
+	return batch.Delete(undoKey)
+}
+
+//updateAddresses add (or remove) the transactions of the block to the address
+//index with write, if the index is enabled.
+func (u *UTXOSet) updateAddresses(batch Batch, block *Block, undo BlockUndo, write func(w Writer, history map[string][]*AddressTx) error) error {
+	enabled, err := hasAddressIndex(batch.Get)
+	if err != nil || !enabled {
+		return err
+	}
+
+	history, err := addressTxs(block, spentOutputs(undo))
+	if err != nil {
+		return err
+	}
+
+	return write(batch, history)
+}
+
+//DeleteByPrefix go throw the DB and delete in bulk the prefix keys from the DB.
+func (u *UTXOSet) DeleteByPrefix(prefix []byte) error {
+	return deleteByPrefix(u.Blockchain.Database, prefix)
+}
+
+//deleteByPrefix delete in bulk all the keys of db that start with prefix.
+func deleteByPrefix(db Store, prefix []byte) error {
+	//the store can't be changed while we iterate, so first we collect all the keys
+	var keysForDelete [][]byte
+	err := db.Iterate(prefix, func(key, value []byte) error {
+		keysForDelete = append(keysForDelete, key) //add the key to delete into keysForDelete
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.BulkWrite(func(w Writer) error {
+		for _, key := range keysForDelete {
+			if err := w.Delete(key); err != nil {
+				return err
 			}
 		}
 		return nil
@@ -0,0 +1,293 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ValidateBlock check a block before it is saved into the db.
+// Every block must be linked to a block that we have, be sealed with the rules
+// of the Consensus, have a version not older than the previous block, commit
+// to the transactions and to their ScriptSigs with the MerkleRoot and the
+// SigRoot and contain exactly one coinbase as first transaction, the
+// transactions must have a known version (the versions older than
+// ScriptTxVersion only inside the legacy blocks) and their LockTime must be
+// before the height or the time of the block. If the block extends
+// the last block, the transactions are also checked against the UTXOSet: the
+// inputs must exist, be spent only once, be unlocked by their scripts, be
+// older than their relative locks and be greater or equal to the outputs.
+// The outputs of every transaction can't be negative.
+// The rules that the block breaks are returned as ErrInvalidBlock.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	if len(block.PrevHash) == 0 {
+		return invalidBlock("Block has no previous block")
+	}
+
+	prevBlock, err := chain.GetHeader(block.PrevHash)
+	if errors.Is(err, ErrBlockNotFound) {
+		return invalidBlock("Previous block is not found")
+	}
+	if err != nil {
+		return err
+	}
+	if block.Height != prevBlock.Height+1 {
+		return invalidBlock("Block height %d doesn't follow the previous block height %d", block.Height, prevBlock.Height)
+	}
+	if block.Version > BlockVersion {
+		return invalidBlock("Block version %d is not known", block.Version)
+	}
+	//the old versions can be only inside the history of the chain
+	if block.Version < prevBlock.Version {
+		return invalidBlock("Block version %d is older than the version %d of the previous block", block.Version, prevBlock.Version)
+	}
+
+	if err := chain.CheckTimestamp(block); err != nil {
+		return err
+	}
+	if err := chain.CheckSeal(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return invalidBlock("Block merkle root doesn't match the transactions")
+	}
+	if !bytes.Equal(block.SigRoot, block.HashSignatures()) {
+		return invalidBlock("Block signature root doesn't match the transactions")
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return invalidBlock("The first transaction of the block is not a coinbase")
+	}
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.ID, tx.Hash()) {
+			return invalidBlock("Transaction %x has a wrong ID", tx.ID)
+		}
+		if tx.Version > TxVersion {
+			return invalidBlock("Transaction %x has the unknown version %d", tx.ID, tx.Version)
+		}
+		//the IDs of the old versions don't cover all the scripts, they can be
+		//only inside the migrated blocks
+		if tx.Version < ScriptTxVersion && block.Version != LegacyBlockVersion {
+			return invalidBlock("Transaction %x has the old version %d", tx.ID, tx.Version)
+		}
+		if !tx.IsFinal(block.Height, block.Timestamp) {
+			return invalidBlock("Transaction %x is locked until %d", tx.ID, tx.LockTime)
+		}
+		if _, err := tx.OutputValue(); err != nil {
+			return invalidBlock("Transaction %x: %s", tx.ID, err)
+		}
+	}
+
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return invalidBlock("Block contains more than one coinbase")
+		}
+
+		for _, in := range tx.Inputs {
+			key := outpoint(in.ID, in.Out)
+			if spent[key] {
+				return invalidBlock("Input %s is spent twice inside the block", key)
+			}
+			spent[key] = true
+		}
+	}
+
+	//the UTXOSet rappresent the last block, so we can check the inputs only
+	//if the block is built on top of it
+	if !bytes.Equal(block.PrevHash, chain.LastHash) {
+		return nil
+	}
+
+	UTXOSet := UTXOSet{Blockchain: chain}
+	fees := 0
+	for _, tx := range block.Transactions[1:] {
+		fee, err := UTXOSet.TransactionFee(tx)
+		if err != nil {
+			return txError(fmt.Errorf("Transaction %x: %w", tx.ID, err))
+		}
+		if fee < 0 {
+			return invalidBlock("Transaction %x spends more than his inputs", tx.ID)
+		}
+		if fees, err = addValue(fees, fee); err != nil {
+			return invalidBlock("Fees of the block: %s", err)
+		}
+
+		if err := chain.CheckSequenceLocks(tx, block.Height, block.Timestamp); err != nil {
+			return txError(fmt.Errorf("Transaction %x: %w", tx.ID, err))
+		}
+		if err := chain.VerifyTransaction(tx); err != nil {
+			return txError(err)
+		}
+	}
+
+	return CheckCoinbase(block, fees)
+}
+
+// CheckSequenceLocks check the relative locks of the inputs of tx: the block at
+// height with the time blockTime can contain tx only if the outputs that it
+// spends are old enough, otherwise it fails with ErrInvalidTx. The outputs
+// must be inside the main chain.
+func (chain *BlockChain) CheckSequenceLocks(tx *Transaction, height int, blockTime int64) error {
+	if tx.Version < SequenceTxVersion || tx.IsCoinbase() {
+		return nil
+	}
+
+	for i, in := range tx.Inputs {
+		value := int64(in.Sequence & SequenceMask)
+		if value == 0 {
+			continue
+		}
+		prevBlock, err := chain.TransactionHeader(in.ID)
+		if err != nil {
+			return err
+		}
+
+		if in.Sequence&SequenceTypeFlag == 0 {
+			if until := prevBlock.Height + int(value); height < until {
+				return fmt.Errorf("%w: input %d is locked until the height %d", ErrInvalidTx, i, until)
+			}
+		} else if until := prevBlock.Timestamp + value<<SequenceGranularity; blockTime < until {
+			return fmt.Errorf("%w: input %d is locked until the time %d", ErrInvalidTx, i, until)
+		}
+	}
+
+	return nil
+}
+
+// CheckSeal check that the Hash of the block is the hash of his header and
+// that the block is sealed with the rules of the Consensus of the chain.
+func (chain *BlockChain) CheckSeal(block *Block) error {
+	if !bytes.Equal(block.Hash, block.BlockHash()) {
+		return invalidBlock("Block hash doesn't match the header")
+	}
+
+	return chain.Consensus.Verify(chain, block)
+}
+
+// CheckProofOfWork check that the block has the expected difficulty for his
+// height and that his hash meets the target.
+func (chain *BlockChain) CheckProofOfWork(block *Block) error {
+	if len(block.PrevHash) > 0 {
+		bits, err := chain.RequiredBits(block.PrevHash)
+		if err != nil {
+			return err
+		}
+		if block.Bits != bits {
+			return invalidBlock("Block has a wrong difficulty")
+		}
+	}
+
+	if block.Version > BlockVersion {
+		return invalidBlock("Block version %d is not known", block.Version)
+	}
+
+	pow := NewProof(&block.BlockHeader)
+	if !pow.Validate() {
+		return invalidBlock("Block has an invalid proof of work")
+	}
+
+	return nil
+}
+
+// CheckTimestamp check that the time of the block is greater than the median
+// time of the previous blocks and that it is not too far in the future.
+func (chain *BlockChain) CheckTimestamp(block *Block) error {
+	if len(block.PrevHash) > 0 {
+		medianTime, err := chain.MedianTimePast(block.PrevHash)
+		if err != nil {
+			return err
+		}
+		if block.Timestamp <= medianTime {
+			return invalidBlock("Block timestamp is not greater than the median time past")
+		}
+	}
+
+	if block.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+		return invalidBlock("Block timestamp is too far in the future")
+	}
+
+	return nil
+}
+
+// CheckCoinbase check that the coinbase of the block contains the height of
+// the block, that its outputs are not negative and that it doesn't pay more
+// than the subsidy plus the fees.
+func CheckCoinbase(block *Block, fees int) error {
+	coinbase := block.Transactions[0]
+	height, err := coinbase.CoinbaseHeight()
+	if err != nil {
+		return invalidBlock("%s", err)
+	}
+	if height != block.Height {
+		return invalidBlock("Coinbase height doesn't match the block height")
+	}
+
+	allowed, err := addValue(BlockSubsidy(block.Height), fees)
+	if err != nil {
+		return invalidBlock("Fees of the block: %s", err)
+	}
+
+	reward := 0
+	for i, out := range coinbase.Outputs {
+		if out.Value < 0 {
+			return invalidBlock("Coinbase output %d has the negative value %d", i, out.Value)
+		}
+		if out.Value > allowed {
+			return invalidBlock("Coinbase output %d pays %d but only %d is allowed", i, out.Value, allowed)
+		}
+		if reward, err = addValue(reward, out.Value); err != nil {
+			return invalidBlock("Coinbase: %s", err)
+		}
+	}
+	if reward > allowed {
+		return invalidBlock("Coinbase pays %d but only %d is allowed", reward, allowed)
+	}
+
+	return nil
+}
+
+// OutputValue return the sum of the outputs of tx, it fails if an output is
+// negative or if the sum overflows.
+func (tx *Transaction) OutputValue() (int, error) {
+	total := 0
+	for i, out := range tx.Outputs {
+		var err error
+		if total, err = addValue(total, out.Value); err != nil {
+			return 0, fmt.Errorf("Output %d: %w", i, err)
+		}
+	}
+
+	return total, nil
+}
+
+// addValue return sum+value, it fails if value is negative or if the sum overflows.
+func addValue(sum, value int) (int, error) {
+	if value < 0 {
+		return 0, fmt.Errorf("Value %d is negative", value)
+	}
+	if sum > math.MaxInt-value {
+		return 0, errors.New("Sum of the values overflows")
+	}
+
+	return sum + value, nil
+}
+
+// txError return the error of a transaction of the block that extends the
+// UTXOSet: the rules that the transaction breaks (ErrInvalidTx and
+// ErrScriptFailed) make the block invalid, the other errors (e.g. of the db)
+// don't say anything about the block and are returned as they are.
+func txError(err error) error {
+	if errors.Is(err, ErrInvalidTx) || errors.Is(err, ErrScriptFailed) {
+		return invalidBlock("%s", err)
+	}
+
+	return err
+}
+
+// invalidBlock return an error that wraps ErrInvalidBlock with the rule that the block breaks.
+func invalidBlock(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBlock, fmt.Sprintf(format, a...))
+}
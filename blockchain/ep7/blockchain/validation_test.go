@@ -0,0 +1,41 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+func TestOldTransactionVersion(t *testing.T) {
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	chain, a := newTestChain(t, ws)
+	b, _ := ws.AddWallet()
+
+	//a valid transaction with the version of the binary format, when the ID
+	//didn't cover the scripts of the outputs
+	w, err := ws.GetWallet(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := UTXOSet{Blockchain: chain}
+	tx, err := NewTransaction(&w, b, 10, 0, TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Version = BinaryTxVersion
+	tx.SetID()
+	if err := chain.SignTransaction(tx, ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewMempool(&UTXOSet).Add(tx); err == nil {
+		t.Error("the mempool accepted a transaction with an old version")
+	}
+	if _, err := chain.AddBlock(a, []*Transaction{tx}); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("AddBlock with a transaction with an old version = %v, want ErrInvalidBlock", err)
+	}
+}
@@ -1,54 +1,150 @@
 package cli
 
 import (
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"math"
 	"os"
-	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/RachidP/BlockChain/blockchain"
+	"github.com/RachidP/BlockChain/network"
 	"github.com/RachidP/BlockChain/wallet"
 )
 
 //CommandLine Allow the user to pass a new Block from command line.
 type CommandLine struct {
+	DataDir string //directory of the chains and the wallets, the -datadir flag change it
+}
+
+// exit codes of the commands, so the scripts can know why a command failed
+const (
+	ExitOK                = 0
+	ExitError             = 1 //an error that has not a specific code
+	ExitUsage             = 2
+	ExitChainNotFound     = 3
+	ExitChainExists       = 4
+	ExitInsufficientFunds = 5
+	ExitUnknownWallet     = 6
+	ExitInvalidAddress    = 7
+	ExitInvalidBlock      = 8
+	ExitOldDatabase       = 9
+)
+
+// errUsage is returned when the command line is not correct, the usage is already printed.
+var errUsage = errors.New("Wrong usage")
+
+// ExitCode return the exit code for the error returned by Run.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, errUsage):
+		return ExitUsage
+	case errors.Is(err, blockchain.ErrChainNotFound):
+		return ExitChainNotFound
+	case errors.Is(err, blockchain.ErrChainExists):
+		return ExitChainExists
+	case errors.Is(err, blockchain.ErrInsufficientFunds):
+		return ExitInsufficientFunds
+	case errors.Is(err, wallet.ErrUnknownWallet):
+		return ExitUnknownWallet
+	case errors.Is(err, wallet.ErrInvalidAddress):
+		return ExitInvalidAddress
+	case errors.Is(err, blockchain.ErrInvalidBlock):
+		return ExitInvalidBlock
+	case errors.Is(err, blockchain.ErrOldDatabase):
+		return ExitOldDatabase
+	default:
+		return ExitError
+	}
 }
 
 func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: [-datadir DIR] [-wallet FILE] COMMAND")
+	fmt.Println(" -datadir DIR - directory of the blockchain and the wallets (default " + blockchain.DefaultDataDir + ")")
+	fmt.Println(" -wallet FILE - wallet file to use instead of the one of the node inside DIR")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println(" createblockchain -address ADDRESS [-authorities ADDR1,ADDR2] creates a blockchain and sends genesis reward to address. With -authorities the blocks are signed in turn by the authorities instead of mined (proof of authority)")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
+	fmt.Println(" printheaders - Prints the headers of the blocks in the chain, without the transactions")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT [-fee FEE | -feerate RATE] [-locktime LOCK] [-relative N|Ns] [-out FILE] -mine - Send amount of coins paying a fee (or RATE for every byte). Then -mine flag is set, mine off of this node and send the reward to FROM. With -out the transaction is saved into FILE instead, so the other owners of a multisig address can sign it")
+	fmt.Println("   -locktime LOCK - the transaction can't be inside a block before the height LOCK, or before the unix time LOCK if it is at least 500000000")
+	fmt.Println("   -relative N|Ns - the transaction can't be inside a block until N blocks (or N seconds) have passed since the outputs that it spends")
 	fmt.Println(" createwallet - Creates a new Wallet")
+	fmt.Println(" createhdwallet [-words 12|24] [-passphrase P] [-path PATH] - Makes the wallet file HD: prints a new mnemonic and the next addresses are derived from its seed along PATH (default " + wallet.DefaultHDPath + ")")
+	fmt.Println(" restorewallet -mnemonic \"WORDS\" [-passphrase P] [-path PATH] [-gap N] - Makes again the wallet file from the mnemonic, with the addresses used by the blockchain until N unused addresses in a row (default 20). The wallet file must not exist")
+	fmt.Println(" createmultisig -required M -pubkeys PK1,PK2 - Creates the address of the outputs that need the signatures of M of the public keys (in hex)")
+	fmt.Println(" createrawtx -from FROM -to TO -amount AMOUNT [-fee FEE] [-locktime LOCK] [-relative N|Ns] -out FILE - Saves into FILE the transaction without signatures, with the outputs that it spends. The wallet of FROM is not needed")
+	fmt.Println(" signrawtx -in FILE [-out FILE] - Prints the transaction saved by createrawtx or send -out and adds the signatures of our wallet file, the blockchain is not needed")
+	fmt.Println(" submitrawtx -in FILE [-miner ADDRESS] - Sends a signed transaction saved into FILE. With -miner it is mined off of this node and the reward is sent to ADDRESS")
 	fmt.Println(" reindexutxo - Rebuilds the UTXO set")
-	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+	fmt.Println(" reindex [-addrindex] - Rebuilds the block index, the transaction index and the UTXO set. -addrindex enables the address index")
+	fmt.Println(" history -address ADDRESS - Lists the transactions of an address (needs the address index)")
+	fmt.Println(" migratedb [-from DIR] - Converts a blockchain created by an old version (or the Badger v1 one inside DIR) to the current database format")
+	fmt.Println(" listaddresses [-pubkeys] - Lists the addresses in our wallet file, with -pubkeys also the public keys and the multisig scripts")
+	fmt.Println(" startnode -miner ADDRESS - Start a node with ID specified in NODE_ID env. var. -miner enables mining")
 
 }
 
 // ValidateArgs validate the args passed from the command line.
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CommandLine) validateArgs(args []string) error {
+	if len(args) < 1 {
 		cli.printUsage()
-		runtime.Goexit() // is a safe exit it shotdown the goroutines and it safe for working with the data in the db
+		return errUsage
+	}
+
+	return nil
+}
+
+// validateAddress return wallet.ErrInvalidAddress if the address is not valid.
+func validateAddress(address string) error {
+	if !wallet.ValidateAddress(address) {
+		return fmt.Errorf("%w: %q", wallet.ErrInvalidAddress, address)
 	}
 
+	return nil
 }
 
-func (cli *CommandLine) printChain() {
-	chain := blockchain.ContinueBlockChain("")
+//startNode cmd for starting a node of the network.
+func (cli *CommandLine) startNode(nodeID, minerAddress string, opts blockchain.Options) error {
+	fmt.Printf("Starting Node %s\n", nodeID)
+
+	if len(minerAddress) > 0 {
+		if err := validateAddress(minerAddress); err != nil {
+			return err
+		}
+		fmt.Println("Mining is on. Address to receive rewards: ", minerAddress)
+	}
+	return network.StartServer(nodeID, minerAddress, opts)
+}
+
+func (cli *CommandLine) printChain(opts blockchain.Options) error {
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
 	defer chain.Database.Close()
 	iter := chain.Iterator()
 
 	for {
-		block := iter.Next()
+		block, err := iter.Next()
+		if err != nil {
+			return err
+		}
 
 		fmt.Printf("Hash: %x\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Timestamp: %s\n", time.Unix(block.Timestamp, 0))
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
 		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
-		pow := blockchain.NewProof(block)
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
+		fmt.Printf("%s: %s\n", chain.Consensus.Name(), strconv.FormatBool(chain.CheckSeal(block) == nil))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
 		}
@@ -58,180 +154,1001 @@ func (cli *CommandLine) printChain() {
 			break
 		}
 	}
+
+	return nil
 }
-func (cli *CommandLine) createBlockChain(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+
+//printHeaders print the headers of the main chain, only the headers are read from the db.
+func (cli *CommandLine) printHeaders(opts blockchain.Options) error {
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+	iter := chain.Iterator()
+
+	for len(iter.CurrentHash) > 0 {
+		block, err := iter.NextHeader()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Hash: %x\n", block.Hash)
+		fmt.Printf("Height: %d\n", block.Height)
+		fmt.Printf("Version: %d\n", block.Version)
+		fmt.Printf("Timestamp: %s\n", time.Unix(block.Timestamp, 0))
+		fmt.Printf("Bits: %08x\n", block.Bits)
+		fmt.Printf("Nonce: %d\n", block.Nonce)
+		fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+		fmt.Printf("Signature root: %x\n", block.SigRoot)
+		fmt.Printf("Prev. hash: %x\n", block.PrevHash)
+		fmt.Printf("%s: %s\n", chain.Consensus.Name(), strconv.FormatBool(chain.CheckSeal(block) == nil))
+		fmt.Println()
+	}
+
+	return nil
+}
+
+//createBlockChain create the chain, it uses the proof of authority if authorities
+//(a list of addresses separated by commas) is not empty.
+func (cli *CommandLine) createBlockChain(address, authorities string, opts blockchain.Options) error {
+	if err := validateAddress(address); err != nil {
+		return err
+	}
+	if authorities != "" {
+		poa, err := blockchain.NewPoA(strings.Split(authorities, ","), opts.WalletFile())
+		if err != nil {
+			return err
+		}
+		opts.Consensus = poa
+	}
+	chain, err := blockchain.InitBlockChain(address, opts)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.InitBlockChain(address)
-	chain.Database.Close()
+	defer chain.Database.Close()
 
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
 
 	fmt.Println("Finished!")
+	return nil
 }
 
-func (cli *CommandLine) getBalance(address string) {
-	if !wallet.ValidateAddress(address) {
-		log.Panic("Address is not Valid")
+func (cli *CommandLine) getBalance(address string, opts blockchain.Options) error {
+	script, err := blockchain.AddressScript(address)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.ContinueBlockChain(address)
-	UTXOSet := blockchain.UTXOSet{chain}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
 	balance := 0
-	pubKeyHash := wallet.Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+	UTXOs, err := UTXOSet.FindUTXO(script)
+	if err != nil {
+		return err
+	}
 
 	for _, out := range UTXOs {
 		balance += out.Value
 	}
 
 	fmt.Printf("Balance of %s: %d\n", address, balance)
+	return nil
 }
 
-//listAddresses cmd for the list of addresses in the wallet.
-func (cli *CommandLine) listAddresses() {
-	wallets, _ := wallet.CreateWallets()
+//listAddresses cmd for the list of addresses in the wallet, if pubKeys is
+//true it prints the public keys of the wallets and the keys required by the scripts.
+func (cli *CommandLine) listAddresses(pubKeys bool, opts blockchain.Options) error {
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
-		fmt.Println(address)
+		if pubKeys {
+			fmt.Printf("%s %x\n", address, wallets.Wallets[address].PublicKey)
+		} else {
+			fmt.Println(address)
+		}
 	}
+
+	for _, address := range wallets.GetScriptAddresses() {
+		required, keys, ok := blockchain.ParseMultisigScript(wallets.Scripts[address])
+		if pubKeys && ok {
+			fmt.Printf("%s multisig %d of %d\n", address, required, len(keys))
+		} else {
+			fmt.Println(address)
+		}
+	}
+
+	return nil
 }
 
 //createWallet cmd for creating a wallet.
-func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
-	address := wallets.AddWallet()
-	wallets.SaveFile()
+func (cli *CommandLine) createWallet(opts blockchain.Options) error {
+	//the first wallet of the node create the file
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(opts.WalletFile()); err != nil {
+		return err
+	}
+
+	fmt.Printf("New address is: %s\n", address)
+	return nil
+}
+
+//createMultisig cmd for creating the address of a multisig script, pubKeys
+//are the public keys in hex separated by commas. Every owner can create the
+//same address with the same keys in the same order.
+func (cli *CommandLine) createMultisig(required int, pubKeys string, opts blockchain.Options) error {
+	var keys [][]byte
+	for _, pubKey := range strings.Split(pubKeys, ",") {
+		key, err := hex.DecodeString(strings.TrimSpace(pubKey))
+		if err != nil {
+			return fmt.Errorf("Public key %q is not valid: %w", pubKey, err)
+		}
+		keys = append(keys, key)
+	}
+	script, err := blockchain.MultisigScript(required, keys)
+	if err != nil {
+		return err
+	}
+
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	address := wallets.AddScript(script)
+	if err := wallets.SaveFile(opts.WalletFile()); err != nil {
+		return err
+	}
+
+	fmt.Printf("New multisig address is: %s\n", address)
+	return nil
+}
+
+//createHDWallet cmd for making the wallet file HD: the next addresses of
+//createwallet are derived from the seed of a new mnemonic along path. The
+//mnemonic is printed only here, with it restorewallet makes the keys again.
+func (cli *CommandLine) createHDWallet(words int, passphrase, path string, opts blockchain.Options) error {
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if wallets.HD != nil {
+		return fmt.Errorf("Wallet file %s has already a seed", opts.WalletFile())
+	}
+	oldKeys := len(wallets.Wallets)
+
+	//every word is 11 bits, 1 bit every 33 is the checksum
+	mnemonic, err := wallet.NewMnemonic(words * 32 / 3)
+	if err != nil {
+		return err
+	}
+	seed, err := wallet.MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := wallets.SetSeed(seed, path); err != nil {
+		return err
+	}
+	address, err := wallets.AddWallet()
+	if err != nil {
+		return err
+	}
+	if err := wallets.SaveFile(opts.WalletFile()); err != nil {
+		return err
+	}
 
+	fmt.Printf("Mnemonic: %s\n", mnemonic)
+	fmt.Println("Write down the mnemonic (and the passphrase), it is the only backup of the keys of the seed")
+	if oldKeys > 0 {
+		fmt.Printf("The wallet file has %d random keys made before the seed, they are not inside the backup\n", oldKeys)
+	}
 	fmt.Printf("New address is: %s\n", address)
+	return nil
+}
+
+//restoreWallet cmd for making again the wallet file from the mnemonic: the
+//keys of the seed are derived until gap addresses in a row have never been
+//used, an address is used if it has unspent outputs or (with the address
+//index) a transaction. The wallet file must not exist, so no key is lost.
+func (cli *CommandLine) restoreWallet(mnemonic, passphrase, path string, gap int, opts blockchain.Options) error {
+	if _, err := os.Stat(opts.WalletFile()); err == nil {
+		return fmt.Errorf("Wallet file %s already exists, use -wallet to restore into another file", opts.WalletFile())
+	}
+	seed, err := wallet.MnemonicSeed(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := wallets.SetSeed(seed, path); err != nil {
+		return err
+	}
+
+	balances := make(map[string]int)
+	last := -1 //index of the last used address
+	chain, err := blockchain.ContinueBlockChain(opts)
+	switch {
+	case errors.Is(err, blockchain.ErrChainNotFound):
+		fmt.Println("No blockchain found, only the first address is restored")
+	case err != nil:
+		return err
+	default:
+		defer chain.Database.Close()
+		last, err = lastUsedKey(chain, wallets.HD, gap, balances)
+		if err != nil {
+			return err
+		}
+	}
+
+	total := 0
+	for index := 0; index <= last || index == 0; index++ {
+		address, err := wallets.AddHDWallet(uint32(index))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %d\n", address, balances[address])
+		total += balances[address]
+	}
+	wallets.HD.Next = uint32(len(wallets.Wallets))
+	if err := wallets.SaveFile(opts.WalletFile()); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored %d addresses with a balance of %d\n", len(wallets.Wallets), total)
+	return nil
+}
+
+//lastUsedKey return the index of the last used key of the seed (-1 if no key
+//is used) looking at gap keys after it, balances are filled with the balances
+//of all the addresses of the UTXO set.
+func lastUsedKey(chain *blockchain.BlockChain, hd *wallet.HDSeed, gap int, balances map[string]int) (int, error) {
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	found, err := UTXOSet.Balances()
+	if err != nil {
+		return 0, err
+	}
+	for address, balance := range found {
+		balances[address] = balance
+	}
+	addrIndex, err := chain.HasAddressIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	last := -1
+	for index := 0; index-last <= gap; index++ {
+		w, err := hd.Key(uint32(index))
+		if err != nil {
+			return 0, err
+		}
+
+		used := balances[string(w.Address())] > 0
+		if !used && addrIndex {
+			history, err := chain.AddressHistory(wallet.PublicKeyHash(w.PublicKey))
+			if err != nil {
+				return 0, err
+			}
+			used = len(history) > 0
+		}
+		if used {
+			last = index
+		}
+	}
+
+	return last, nil
 }
 
-func (cli *CommandLine) reindexUTXO() {
-	chain := blockchain.ContinueBlockChain("")
+func (cli *CommandLine) reindexUTXO(opts blockchain.Options) error {
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
 	defer chain.Database.Close()
-	UTXOSet := blockchain.UTXOSet{chain}
-	UTXOSet.Reindex()
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	return nil
+}
+
+//reindex rebuild all the indexes of the chain from the blocks, if addrIndex
+//is true the address index is enabled.
+func (cli *CommandLine) reindex(addrIndex bool, opts blockchain.Options) error {
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	//ReindexBlocks rebuild also the address index if it is already enabled
+	if err := chain.ReindexBlocks(); err != nil {
+		return err
+	}
+	enabled, err := chain.HasAddressIndex()
+	if err != nil {
+		return err
+	}
+	if addrIndex && !enabled {
+		if err := chain.ReindexAddresses(); err != nil {
+			return err
+		}
+		fmt.Println("Address index enabled")
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		return err
+	}
+
+	height, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done! %d blocks indexed, there are %d transactions in the UTXO set.\n", height+1, count)
+	return nil
 }
 
-func (cli *CommandLine) send(from, to string, amount int) {
-	if !wallet.ValidateAddress(to) {
-		log.Panic("Address is not Valid")
+//history print the transactions of the address, it needs the address index.
+func (cli *CommandLine) history(address string, opts blockchain.Options) error {
+	pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		return err
 	}
-	if !wallet.ValidateAddress(from) {
-		log.Panic("Address is not Valid")
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
 	}
-	chain := blockchain.ContinueBlockChain(from)
-	UTXOSet := blockchain.UTXOSet{chain}
 	defer chain.Database.Close()
 
-	tx := blockchain.NewTransaction(from, to, amount, &UTXOSet)
-	block := chain.AddBlock([]*blockchain.Transaction{tx})
-	UTXOSet.Update(block)
+	history, err := chain.AddressHistory(pubKeyHash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("History of %s:\n", address)
+	for _, a := range history {
+		fmt.Printf("%x height %d %-3s %d\n", a.TxID, a.Height, a.Direction(), a.Amount())
+	}
+	return nil
+}
+
+//migrateDB convert the chain created by an old version: the Badger v1 db
+//inside from (or the db of the chain if from is empty) is copied into a new db,
+//then the blocks encoded with gob are converted to the binary format.
+func (cli *CommandLine) migrateDB(from string, opts blockchain.Options) error {
+	if from == "" {
+		from = opts.DBPath()
+	}
+	old, err := blockchain.IsBadgerV1(from)
+	if err != nil {
+		return err
+	}
+	if !old && from != opts.DBPath() {
+		return fmt.Errorf("%s is not a Badger v1 database", from)
+	}
+
+	if old {
+		count, err := blockchain.MigrateV1(from, opts)
+		if err != nil {
+			return err
+		}
+		if from == opts.DBPath() {
+			fmt.Printf("%d keys copied, the old database is in %s.v1\n", count, opts.DBPath())
+		} else {
+			fmt.Printf("%d keys copied from %s into %s\n", count, from, opts.DBPath())
+		}
+	}
+
+	count, err := blockchain.MigrateEncoding(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done! %d values converted to the current format\n", count)
+	return nil
+}
+
+//parseLock return the lock of the flags -locktime and -relative: relative is
+//a number of blocks, or of seconds with the suffix "s" (rounded up to the
+//units of 512 seconds of the Sequence).
+func parseLock(lockTime uint, relative string) (blockchain.TxLock, error) {
+	lock := blockchain.TxLock{}
+	if lockTime > math.MaxUint32 {
+		return lock, fmt.Errorf("Lock time %d is too big", lockTime)
+	}
+	lock.LockTime = uint32(lockTime)
+	if relative == "" {
+		return lock, nil
+	}
+
+	seconds := strings.HasSuffix(relative, "s")
+	n, err := strconv.ParseUint(strings.TrimSuffix(relative, "s"), 10, 32)
+	if err != nil {
+		return lock, fmt.Errorf("Relative lock %q is not valid: %w", relative, err)
+	}
+	if seconds {
+		unit := uint64(1) << blockchain.SequenceGranularity
+		n = (n + unit - 1) / unit
+	}
+	if n > blockchain.SequenceMask {
+		return lock, fmt.Errorf("Relative lock %q is too big", relative)
+	}
+	lock.Sequence = uint32(n)
+	if seconds {
+		lock.Sequence |= blockchain.SequenceTypeFlag
+	}
+
+	return lock, nil
+}
+
+//send create a transaction, if mineNow is true the transaction is mined by
+//this node, otherwise it is sent to the central node of the network.
+//If feeRate is greater than 0 the fee is calculated from the size of the transaction.
+//If out is not empty the transaction is saved into the file out, even if it
+//needs the signatures of the other owners of a multisig address.
+func (cli *CommandLine) send(from, to string, amount, fee, feeRate int, lock blockchain.TxLock, out string, opts blockchain.Options, mineNow bool) error {
+	if err := validateAddress(to); err != nil {
+		return err
+	}
+	if err := validateAddress(from); err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	defer chain.Database.Close()
+
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", wallet.ErrUnknownWallet, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	var tx *blockchain.Transaction
+	if wallet.IsScriptAddress(from) {
+		//the size of the transaction depends on the signatures that are not there yet
+		if feeRate > 0 {
+			return errors.New("The fee of a multisig address can't be a rate, use -fee")
+		}
+		if _, err := wallets.GetScript(from); err != nil {
+			return err
+		}
+		tx, err = blockchain.NewUnsignedTransaction(from, to, amount, fee, lock, &UTXOSet)
+		if err == nil {
+			err = chain.SignTransaction(tx, wallets)
+		}
+	} else {
+		w, err := wallets.GetWallet(from)
+		if err != nil {
+			return err
+		}
+		if feeRate > 0 {
+			tx, err = blockchain.NewTransactionWithFeeRate(&w, to, amount, feeRate, lock, &UTXOSet)
+		} else {
+			tx, err = blockchain.NewTransaction(&w, to, amount, fee, lock, &UTXOSet)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	txFee, err := UTXOSet.TransactionFee(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fee: %d\n", txFee)
+
+	if out != "" {
+		p, err := chain.NewPartialTransaction(tx)
+		if err != nil {
+			return err
+		}
+		return writeRawTx(out, p)
+	}
+	if err := chain.VerifyTransaction(tx); err != nil {
+		return fmt.Errorf("The transaction needs the signatures of the other owners, save it with -out: %w", err)
+	}
+
+	miner := ""
+	if mineNow {
+		miner = from
+	}
+	return submitTx(chain, tx, miner)
+}
+
+//submitTx mine the transaction on this node and send the reward to miner, or
+//send it to the central node of the network if miner is empty.
+func submitTx(chain *blockchain.BlockChain, tx *blockchain.Transaction, miner string) error {
+	if miner != "" {
+		_, err := chain.AddBlock(miner, []*blockchain.Transaction{tx})
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := network.SendTx(network.CentralNode, tx); err != nil {
+			return err
+		}
+		fmt.Println("send tx")
+	}
+
 	fmt.Println("Success!")
+	return nil
+}
+
+//writeRawTx save the transaction into the file, and say if it needs more signatures.
+func writeRawTx(file string, p *blockchain.PartialTransaction) error {
+	if err := ioutil.WriteFile(file, p.Serialize(), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Transaction %x saved into %s\n", p.Tx.ID, file)
+	if err := p.Verify(); err != nil {
+		fmt.Printf("It needs more signatures (%s)\n", err)
+	} else {
+		fmt.Println("It is signed, it can be sent with submitrawtx")
+	}
+	return nil
+}
+
+//printRawTx print the spent outputs, the outputs and the fee of the transaction,
+//so the signer can check what he is signing.
+func printRawTx(p *blockchain.PartialTransaction) error {
+	prevOutputs, err := p.PrevOutputs()
+	if err != nil {
+		return err
+	}
+	fee, err := p.Fee()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Transaction %x:\n", p.Tx.ID)
+	for i, out := range prevOutputs {
+		fmt.Printf("  Input %d:  %d from %s", i, out.Value, outputAddress(out))
+		if sequence := p.Tx.Inputs[i].Sequence; sequence != 0 {
+			fmt.Printf(" (Sequence %08x)", sequence)
+		}
+		fmt.Println()
+	}
+	for i, out := range p.Tx.Outputs {
+		fmt.Printf("  Output %d: %d to %s\n", i, out.Value, outputAddress(out))
+	}
+	if p.Tx.LockTime != 0 {
+		fmt.Printf("  LockTime: %d\n", p.Tx.LockTime)
+	}
+	fmt.Printf("  Fee: %d\n", fee)
+	return nil
+}
+
+//outputAddress return the address of the output, or its script if it has not an address.
+func outputAddress(out blockchain.TxOutput) string {
+	if address := out.Address(); address != "" {
+		return address
+	}
+	return blockchain.ScriptString(out.ScriptPubKey)
 }
 
-func (cli *CommandLine) Run() {
-	cli.validateArgs()
+//readRawTx read the transaction saved by writeRawTx.
+func readRawTx(file string) (*blockchain.PartialTransaction, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockchain.DeserializePartialTransaction(data)
+}
+
+//createRawTx save into the file out the transaction that spends the outputs of
+//from, without signatures, so it can be signed on another machine with signrawtx.
+func (cli *CommandLine) createRawTx(from, to string, amount, fee int, lock blockchain.TxLock, out string, opts blockchain.Options) error {
+	if err := validateAddress(to); err != nil {
+		return err
+	}
+	if err := validateAddress(from); err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	tx, err := blockchain.NewUnsignedTransaction(from, to, amount, fee, lock, &UTXOSet)
+	if err != nil {
+		return err
+	}
+	p, err := chain.NewPartialTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := printRawTx(p); err != nil {
+		return err
+	}
+	return writeRawTx(out, p)
+}
+
+//signRawTx add the signatures of the wallet file to the transaction inside the
+//file in, the chain is not needed. The result is saved into out, or into in
+//if out is empty.
+func (cli *CommandLine) signRawTx(in, out string, opts blockchain.Options) error {
+	p, err := readRawTx(in)
+	if err != nil {
+		return err
+	}
+	if err := printRawTx(p); err != nil {
+		return err
+	}
+	wallets, err := wallet.CreateWallets(opts.WalletFile())
+	if err != nil {
+		return err
+	}
+	if err := p.Sign(wallets); err != nil {
+		return err
+	}
+
+	if out == "" {
+		out = in
+	}
+	return writeRawTx(out, p)
+}
+
+//submitRawTx send the signed transaction inside the file in, or mine it if
+//miner is not empty.
+func (cli *CommandLine) submitRawTx(in, miner string, opts blockchain.Options) error {
+	if miner != "" {
+		if err := validateAddress(miner); err != nil {
+			return err
+		}
+	}
+	p, err := readRawTx(in)
+	if err != nil {
+		return err
+	}
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	//the outputs are checked against the chain, not against the file
+	if err := chain.VerifyTransaction(p.Tx); err != nil {
+		return err
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	txFee, err := UTXOSet.TransactionFee(p.Tx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Fee: %d\n", txFee)
+
+	return submitTx(chain, p.Tx, miner)
+}
+
+// Run execute the command of the command line, the error can be converted
+// into the exit code of the program with ExitCode.
+func (cli *CommandLine) Run() error {
+	//the global flags are before the command
+	dataDir := cli.DataDir
+	if dataDir == "" {
+		dataDir = blockchain.DefaultDataDir
+	}
+	globalCmd := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	globalCmd.StringVar(&dataDir, "datadir", dataDir, "Directory of the blockchain and the wallets")
+	walletPath := globalCmd.String("wallet", "", "Wallet file, instead of the one of the node")
+	globalCmd.Usage = cli.printUsage
+	if err := globalCmd.Parse(os.Args[1:]); err != nil {
+		return errUsage
+	}
+	args := globalCmd.Args()
+
+	if err := cli.validateArgs(args); err != nil {
+		return err
+	}
+
+	//every node has his own db and wallet file
+	nodeID := os.Getenv("NODE_ID")
+	if nodeID == "" {
+		fmt.Println("NODE_ID env is not set!")
+		return errUsage
+	}
+	opts := blockchain.DefaultOptions(nodeID)
+	opts.DataDir = dataDir
+	opts.WalletPath = *walletPath
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
+	printHeadersCmd := flag.NewFlagSet("printheaders", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	migrateDBCmd := flag.NewFlagSet("migratedb", flag.ExitOnError)
+	reindexCmd := flag.NewFlagSet("reindex", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	createMultisigCmd := flag.NewFlagSet("createmultisig", flag.ExitOnError)
+	createHDWalletCmd := flag.NewFlagSet("createhdwallet", flag.ExitOnError)
+	restoreWalletCmd := flag.NewFlagSet("restorewallet", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtx", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtx", flag.ExitOnError)
+	submitRawTxCmd := flag.NewFlagSet("submitrawtx", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainAuthorities := createBlockchainCmd.String("authorities", "", "Addresses of the authorities that sign the blocks in turn, separated by commas (proof of authority)")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee paid to the miner for every byte of the transaction")
+	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendOut := sendCmd.String("out", "", "Save the transaction into the file instead of sending it")
+	sendLockTime := sendCmd.Uint("locktime", 0, "Height or unix time before which the transaction can't be mined")
+	sendRelative := sendCmd.String("relative", "", "Blocks (or seconds with the suffix s) after the spent outputs before which the transaction can't be mined")
+	listAddressesPubKeys := listAddressesCmd.Bool("pubkeys", false, "Print the public keys and the multisig scripts")
+	createMultisigRequired := createMultisigCmd.Int("required", 0, "Number of signatures needed to spend")
+	createMultisigPubKeys := createMultisigCmd.String("pubkeys", "", "Public keys in hex separated by commas")
+	createHDWalletWords := createHDWalletCmd.Int("words", 12, "Number of words of the mnemonic: 12, 15, 18, 21 or 24")
+	createHDWalletPassphrase := createHDWalletCmd.String("passphrase", "", "Passphrase that protects the seed, it is needed to restore the wallet")
+	createHDWalletPath := createHDWalletCmd.String("path", wallet.DefaultHDPath, "Derivation path of the parent of the keys")
+	restoreWalletMnemonic := restoreWalletCmd.String("mnemonic", "", "Words of the mnemonic separated by spaces")
+	restoreWalletPassphrase := restoreWalletCmd.String("passphrase", "", "Passphrase of the seed")
+	restoreWalletPath := restoreWalletCmd.String("path", wallet.DefaultHDPath, "Derivation path of the parent of the keys")
+	restoreWalletGap := restoreWalletCmd.Int("gap", 20, "Number of unused addresses in a row after which the scan stops")
+	createRawTxFrom := createRawTxCmd.String("from", "", "Source address")
+	createRawTxTo := createRawTxCmd.String("to", "", "Destination address")
+	createRawTxAmount := createRawTxCmd.Int("amount", 0, "Amount to send")
+	createRawTxFee := createRawTxCmd.Int("fee", 0, "Fee paid to the miner")
+	createRawTxOut := createRawTxCmd.String("out", "", "File where the transaction is saved")
+	createRawTxLockTime := createRawTxCmd.Uint("locktime", 0, "Height or unix time before which the transaction can't be mined")
+	createRawTxRelative := createRawTxCmd.String("relative", "", "Blocks (or seconds with the suffix s) after the spent outputs before which the transaction can't be mined")
+	signRawTxIn := signRawTxCmd.String("in", "", "File of the transaction")
+	signRawTxOut := signRawTxCmd.String("out", "", "File where the signed transaction is saved, the same file if it is empty")
+	submitRawTxIn := submitRawTxCmd.String("in", "", "File of the signed transaction")
+	submitRawTxMiner := submitRawTxCmd.String("miner", "", "Mine immediately on the same node and send the reward to ADDRESS")
+	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	migrateDBFrom := migrateDBCmd.String("from", "", "Badger v1 database to copy, e.g. ./tmp/blocks")
+	reindexAddrIndex := reindexCmd.Bool("addrindex", false, "Enable the index of the transactions of every address")
+	historyAddress := historyCmd.String("address", "", "The address to list the transactions for")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	case "reindexutxo":
-		err := reindexUTXOCmd.Parse(os.Args[2:])
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "history":
+		err := historyCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "reindex":
+		err := reindexCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "migratedb":
+		err := migrateDBCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "createmultisig":
+		err := createMultisigCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "createhdwallet":
+		err := createHDWalletCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "restorewallet":
+		err := restoreWalletCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+	case "createrawtx":
+		err := createRawTxCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "signrawtx":
+		err := signRawTxCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "submitrawtx":
+		err := submitRawTxCmd.Parse(args[1:])
+		if err != nil {
+			return err
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "printheaders":
+		err := printHeadersCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+	case "startnode":
+		err := startNodeCmd.Parse(args[1:])
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 	default:
 		cli.printUsage()
-		runtime.Goexit()
+		return errUsage
 	}
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
 			getBalanceCmd.Usage()
-			runtime.Goexit()
+			return errUsage
 		}
-		cli.getBalance(*getBalanceAddress)
+		return cli.getBalance(*getBalanceAddress, opts)
 	}
 
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
-			runtime.Goexit()
+			return errUsage
 		}
-		cli.createBlockChain(*createBlockchainAddress)
+		return cli.createBlockChain(*createBlockchainAddress, *createBlockchainAuthorities, opts)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain()
+		return cli.printChain(opts)
+	}
+
+	if printHeadersCmd.Parsed() {
+		return cli.printHeaders(opts)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.createWallet()
+		return cli.createWallet(opts)
 	}
 	if listAddressesCmd.Parsed() {
-		cli.listAddresses()
+		return cli.listAddresses(*listAddressesPubKeys, opts)
+	}
+	if createMultisigCmd.Parsed() {
+		if *createMultisigRequired <= 0 || *createMultisigPubKeys == "" {
+			createMultisigCmd.Usage()
+			return errUsage
+		}
+		return cli.createMultisig(*createMultisigRequired, *createMultisigPubKeys, opts)
+	}
+	if createHDWalletCmd.Parsed() {
+		if *createHDWalletWords < 12 || *createHDWalletWords > 24 || *createHDWalletWords%3 != 0 {
+			createHDWalletCmd.Usage()
+			return errUsage
+		}
+		return cli.createHDWallet(*createHDWalletWords, *createHDWalletPassphrase, *createHDWalletPath, opts)
+	}
+	if restoreWalletCmd.Parsed() {
+		if *restoreWalletMnemonic == "" || *restoreWalletGap <= 0 {
+			restoreWalletCmd.Usage()
+			return errUsage
+		}
+		return cli.restoreWallet(*restoreWalletMnemonic, *restoreWalletPassphrase, *restoreWalletPath, *restoreWalletGap, opts)
+	}
+	if createRawTxCmd.Parsed() {
+		if *createRawTxFrom == "" || *createRawTxTo == "" || *createRawTxAmount <= 0 || *createRawTxFee < 0 || *createRawTxOut == "" {
+			createRawTxCmd.Usage()
+			return errUsage
+		}
+		lock, err := parseLock(*createRawTxLockTime, *createRawTxRelative)
+		if err != nil {
+			return err
+		}
+		return cli.createRawTx(*createRawTxFrom, *createRawTxTo, *createRawTxAmount, *createRawTxFee, lock, *createRawTxOut, opts)
+	}
+	if signRawTxCmd.Parsed() {
+		if *signRawTxIn == "" {
+			signRawTxCmd.Usage()
+			return errUsage
+		}
+		return cli.signRawTx(*signRawTxIn, *signRawTxOut, opts)
+	}
+	if submitRawTxCmd.Parsed() {
+		if *submitRawTxIn == "" {
+			submitRawTxCmd.Usage()
+			return errUsage
+		}
+		return cli.submitRawTx(*submitRawTxIn, *submitRawTxMiner, opts)
 	}
 	if reindexUTXOCmd.Parsed() {
-		cli.reindexUTXO()
+		return cli.reindexUTXO(opts)
+	}
+	if reindexCmd.Parsed() {
+		return cli.reindex(*reindexAddrIndex, opts)
+	}
+	if historyCmd.Parsed() {
+		if *historyAddress == "" {
+			historyCmd.Usage()
+			return errUsage
+		}
+		return cli.history(*historyAddress, opts)
+	}
+	if migrateDBCmd.Parsed() {
+		return cli.migrateDB(*migrateDBFrom, opts)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendFeeRate < 0 || (*sendFee > 0 && *sendFeeRate > 0) {
 			sendCmd.Usage()
-			runtime.Goexit()
+			return errUsage
+		}
+
+		lock, err := parseLock(*sendLockTime, *sendRelative)
+		if err != nil {
+			return err
 		}
+		return cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendFeeRate, lock, *sendOut, opts, *sendMine)
+	}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+	if startNodeCmd.Parsed() {
+		return cli.startNode(nodeID, *startNodeMiner, opts)
 	}
+
+	return nil
 }
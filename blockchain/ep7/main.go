@@ -1,13 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/RachidP/BlockChain/cli"
 )
 
 func main() {
-	defer os.Exit(0)
 	cmd := cli.CommandLine{}
-	cmd.Run()
+	if err := cmd.Run(); err != nil {
+		fmt.Println(err)
+		os.Exit(cli.ExitCode(err))
+	}
 }
@@ -0,0 +1,9 @@
+package network
+
+import "errors"
+
+// Errors returned by the network package, the callers can check them with errors.Is.
+var (
+	ErrInvalidMessage   = errors.New("Message is not valid")
+	ErrNodeNotAvailable = errors.New("Node is not available")
+)
@@ -0,0 +1,628 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RachidP/BlockChain/blockchain"
+)
+
+const (
+	protocol      = "tcp"
+	version       = 1       //version of the protocol
+	commandLength = 12      //every message start with a command of 12 bytes
+	maxBlockSize  = 1000000 //max size in bytes of the transactions of a mined block
+
+	maxMessageSize = 32 << 20         //a bigger message is dropped, the inv of the blocks has 33 bytes for every block
+	readTimeout    = 30 * time.Second //time to receive a whole message
+)
+
+var (
+	nodeAddress     string                  //address of this node (localhost:NODE_ID)
+	mineAddress     string                  //address that receive the reward if this node is a miner
+	CentralNode     = "localhost:3000"      //the node that relay the blocks and the transactions to the others
+	KnownNodes      = []string{CentralNode} //the unreachable nodes are removed, also the central node
+	blocksInTransit = [][]byte{}            //blocks that we have to download from another node
+	mempool         *blockchain.Mempool     //transactions waiting to be mined
+	stopMining      context.CancelFunc      //stops the block that is being mined, nil if the node is not mining
+
+	mu sync.Mutex //only one message at time can touch the chain and the variables above
+)
+
+// Addr send the list of the known nodes
+type Addr struct {
+	AddrList []string
+}
+
+// Block send a serialized block
+type Block struct {
+	AddrFrom string
+	Block    []byte
+}
+
+// GetBlocks ask for the hashes of all the blocks of a node
+type GetBlocks struct {
+	AddrFrom string
+}
+
+// GetData ask for a single block or transaction
+type GetData struct {
+	AddrFrom string
+	Type     string //"block" or "tx"
+	ID       []byte
+}
+
+// Inv tell to the other nodes which blocks or transactions we have
+type Inv struct {
+	AddrFrom string
+	Type     string //"block" or "tx"
+	Items    [][]byte
+}
+
+// Tx send a serialized transaction
+type Tx struct {
+	AddrFrom    string
+	Transaction []byte
+}
+
+// Version is the first message sent to a node, used to compare the chains
+type Version struct {
+	Version    int
+	BestHeight int
+	AddrFrom   string
+}
+
+// CmdToBytes convert a command into a []byte of commandLength bytes
+func CmdToBytes(cmd string) []byte {
+	var bytes [commandLength]byte
+
+	for i, c := range cmd {
+		bytes[i] = byte(c)
+	}
+
+	return bytes[:]
+}
+
+// BytesToCmd convert the first bytes of a message into a command
+func BytesToCmd(bytes []byte) string {
+	var cmd []byte
+
+	for _, b := range bytes {
+		if b != 0x0 {
+			cmd = append(cmd, b)
+		}
+	}
+
+	return fmt.Sprintf("%s", cmd)
+}
+
+// ExtractCmd take the command from a request
+func ExtractCmd(request []byte) []byte {
+	return request[:commandLength]
+}
+
+// RequestBlocks ask the blocks to all the known nodes
+func RequestBlocks() {
+	for _, node := range KnownNodes {
+		logSendError(SendGetBlocks(node))
+	}
+}
+
+// SendAddr send our known nodes to address
+func SendAddr(address string) error {
+	nodes := Addr{KnownNodes}
+	nodes.AddrList = append(nodes.AddrList, nodeAddress)
+
+	return sendCommand(address, "addr", nodes)
+}
+
+// SendBlock send a block to address
+func SendBlock(address string, b *blockchain.Block) error {
+	return sendCommand(address, "block", Block{nodeAddress, b.Serialize()})
+}
+
+// SendInv send an inventory of blocks or transactions to address
+func SendInv(address, kind string, items [][]byte) error {
+	return sendCommand(address, "inv", Inv{nodeAddress, kind, items})
+}
+
+// SendTx send a transaction to address
+func SendTx(address string, tnx *blockchain.Transaction) error {
+	return sendCommand(address, "tx", Tx{nodeAddress, tnx.Serialize()})
+}
+
+// SendVersion send our version and our height to address
+func SendVersion(address string, chain *blockchain.BlockChain) error {
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+
+	return sendCommand(address, "version", Version{version, bestHeight, nodeAddress})
+}
+
+// SendGetBlocks ask to address the hashes of his blocks
+func SendGetBlocks(address string) error {
+	return sendCommand(address, "getblocks", GetBlocks{nodeAddress})
+}
+
+// SendGetData ask to address a block or a transaction
+func SendGetData(address, kind string, id []byte) error {
+	return sendCommand(address, "getdata", GetData{nodeAddress, kind, id})
+}
+
+// sendCommand send to address the command followed by the encoded payload
+func sendCommand(address, command string, payload interface{}) error {
+	data, err := GobEncode(payload)
+	if err != nil {
+		return err
+	}
+
+	return SendData(address, append(CmdToBytes(command), data...))
+}
+
+// logSendError print the error of a message that we send to another node, a
+// node that doesn't answer must not stop the node.
+func logSendError(err error) {
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// SendData open a connection to addr and write the data, if the node is not
+// reachable it is removed from the known nodes and the error is ErrNodeNotAvailable
+func SendData(addr string, data []byte) error {
+	conn, err := net.Dial(protocol, addr)
+
+	if err != nil {
+		var updatedNodes []string
+
+		for _, node := range KnownNodes {
+			if node != addr {
+				updatedNodes = append(updatedNodes, node)
+			}
+		}
+
+		KnownNodes = updatedNodes
+
+		return fmt.Errorf("%w: %s", ErrNodeNotAvailable, addr)
+	}
+
+	defer conn.Close()
+
+	_, err = io.Copy(conn, bytes.NewReader(data))
+	return err
+}
+
+// decodePayload decode the payload of a request after the command, it fails
+// with ErrInvalidMessage if the other node sent wrong data.
+func decodePayload(request []byte, payload interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(request[commandLength:]))
+	if err := dec.Decode(payload); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+
+	return nil
+}
+
+// HandleAddr add the received nodes to our known nodes and ask them the blocks
+func HandleAddr(request []byte) error {
+	var payload Addr
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	for _, node := range payload.AddrList {
+		if node != nodeAddress && !NodeIsKnown(node) {
+			KnownNodes = append(KnownNodes, node)
+		}
+	}
+	fmt.Printf("there are %d known nodes\n", len(KnownNodes))
+	RequestBlocks()
+	return nil
+}
+
+// HandleBlock store the received block and ask the next block in transit
+func HandleBlock(request []byte, chain *blockchain.BlockChain) error {
+	var payload Block
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	blockData := payload.Block
+	block, err := blockchain.Deserialize(blockData)
+	if err != nil {
+		return fmt.Errorf("%w: the block can't be decoded: %s", ErrInvalidMessage, err)
+	}
+
+	fmt.Println("Received a new block!")
+	lastHash := chain.LastHash
+	if err := chain.ImportBlock(block); err != nil {
+		fmt.Printf("Block %x rejected: %s\n", block.Hash, err)
+		blocksInTransit = [][]byte{}
+		return nil
+	}
+
+	fmt.Printf("Added block %x\n", block.Hash)
+
+	//the transactions inside the block are not pending anymore
+	mempool.RemoveBlock(block)
+
+	//the block that we are mining doesn't follow the new last block anymore
+	if !bytes.Equal(lastHash, chain.LastHash) && stopMining != nil {
+		stopMining()
+		stopMining = nil
+		MineTx(chain)
+	}
+
+	//the central node relay the block to the other nodes
+	if nodeAddress == CentralNode {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				logSendError(SendInv(node, "block", [][]byte{block.Hash}))
+			}
+		}
+	}
+
+	if len(blocksInTransit) > 0 {
+		blockHash := blocksInTransit[0]
+		blocksInTransit = blocksInTransit[1:]
+
+		return SendGetData(payload.AddrFrom, "block", blockHash)
+	}
+
+	return nil
+}
+
+// HandleInv ask the blocks or the transactions of the inventory that we don't have
+func HandleInv(request []byte, chain *blockchain.BlockChain) error {
+	var payload Inv
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	fmt.Printf("Recevied inventory with %d %s\n", len(payload.Items), payload.Type)
+
+	if payload.Type == "block" {
+		//the inventory goes from the last block to the genesis, we download
+		//the missing blocks starting from the oldest so every block extend our chain
+		blocksInTransit = [][]byte{}
+		for i := len(payload.Items) - 1; i >= 0; i-- {
+			if _, err := chain.GetBlock(payload.Items[i]); err != nil {
+				blocksInTransit = append(blocksInTransit, payload.Items[i])
+			}
+		}
+
+		if len(blocksInTransit) == 0 {
+			return nil
+		}
+
+		blockHash := blocksInTransit[0]
+		blocksInTransit = blocksInTransit[1:]
+
+		return SendGetData(payload.AddrFrom, "block", blockHash)
+	}
+
+	if payload.Type == "tx" {
+		for _, txID := range payload.Items {
+			if _, ok := mempool.Get(txID); ok {
+				continue
+			}
+			if err := SendGetData(payload.AddrFrom, "tx", txID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// HandleGetBlocks send the hashes of all our blocks
+func HandleGetBlocks(request []byte, chain *blockchain.BlockChain) error {
+	var payload GetBlocks
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	blocks, err := chain.GetBlockHashes()
+	if err != nil {
+		return err
+	}
+
+	return SendInv(payload.AddrFrom, "block", blocks)
+}
+
+// HandleGetData send the requested block or transaction
+func HandleGetData(request []byte, chain *blockchain.BlockChain) error {
+	var payload GetData
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	if payload.Type == "block" {
+		block, err := chain.GetBlock([]byte(payload.ID))
+		if err != nil {
+			return nil
+		}
+
+		return SendBlock(payload.AddrFrom, &block)
+	}
+
+	if payload.Type == "tx" {
+		tx, ok := mempool.Get(payload.ID)
+		if !ok {
+			return nil
+		}
+
+		return SendTx(payload.AddrFrom, tx)
+	}
+
+	return nil
+}
+
+// HandleTx put the transaction in the mempool if it is valid, the central node
+// relay it to the other nodes and the miners mine it
+func HandleTx(request []byte, chain *blockchain.BlockChain) error {
+	var payload Tx
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	txData := payload.Transaction
+	tx, err := blockchain.DeserializeTransaction(txData)
+	if err != nil {
+		return fmt.Errorf("%w: the transaction can't be decoded: %s", ErrInvalidMessage, err)
+	}
+	if err := mempool.Add(&tx); err != nil {
+		fmt.Printf("Transaction %x rejected: %s\n", tx.ID, err)
+		return nil
+	}
+
+	fmt.Printf("%s, %d\n", nodeAddress, mempool.Count())
+
+	if nodeAddress == CentralNode {
+		for _, node := range KnownNodes {
+			if node != nodeAddress && node != payload.AddrFrom {
+				logSendError(SendInv(node, "tx", [][]byte{tx.ID}))
+			}
+		}
+	} else if len(mineAddress) > 0 {
+		MineTx(chain)
+	}
+
+	return nil
+}
+
+// MineTx mine the transactions with the highest fee rate of the mempool into
+// a new block and tell to the other nodes that there is a new block.
+// The block is mined by a goroutine, so the node can receive the blocks of
+// the other nodes and stop the mining when the last block changes. Only one
+// block at time is mined, the transactions received in the meantime are
+// mined after it.
+func MineTx(chain *blockchain.BlockChain) {
+	if stopMining != nil {
+		return
+	}
+
+	txs := mempool.BuildBlockTemplate(maxBlockSize)
+
+	if len(txs) == 0 {
+		fmt.Println("No transactions to mine")
+		return
+	}
+
+	newBlock, err := chain.NewBlockTemplate(mineAddress, txs)
+	if err != nil {
+		fmt.Printf("Can't create the block to mine: %s\n", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopMining = cancel
+
+	go func() {
+		err := chain.Consensus.Seal(ctx, newBlock)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		//a new last block has stopped the mining, and maybe started another one
+		if ctx.Err() != nil {
+			fmt.Println("Mining stopped, the last block has changed")
+			return
+		}
+		stopMining = nil
+		cancel()
+		if err != nil {
+			fmt.Printf("Mining failed: %s\n", err)
+			return
+		}
+
+		if err := chain.ImportBlock(newBlock); err != nil {
+			fmt.Printf("Mined block is not valid: %s\n", err)
+			return
+		}
+
+		fmt.Println("New Block mined")
+
+		mempool.RemoveBlock(newBlock)
+
+		for _, node := range KnownNodes {
+			if node != nodeAddress {
+				logSendError(SendInv(node, "block", [][]byte{newBlock.Hash}))
+			}
+		}
+
+		//mine the transactions received during the mining
+		if mempool.Count() > 0 {
+			MineTx(chain)
+		}
+	}()
+}
+
+// HandleVersion compare our chain with the chain of the other node and
+// ask the blocks if the other chain is longer
+func HandleVersion(request []byte, chain *blockchain.BlockChain) error {
+	var payload Version
+	if err := decodePayload(request, &payload); err != nil {
+		return err
+	}
+
+	bestHeight, err := chain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	otherHeight := payload.BestHeight
+
+	if bestHeight < otherHeight {
+		err = SendGetBlocks(payload.AddrFrom)
+	} else if bestHeight > otherHeight {
+		err = SendVersion(payload.AddrFrom, chain)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !NodeIsKnown(payload.AddrFrom) {
+		KnownNodes = append(KnownNodes, payload.AddrFrom)
+
+		//the central node tell to the new node who are the others
+		if nodeAddress == CentralNode {
+			return SendAddr(payload.AddrFrom)
+		}
+	}
+
+	return nil
+}
+
+// HandleConnection read a message and call the handler of his command, a
+// message that fails is printed and the connection is closed
+func HandleConnection(conn net.Conn, chain *blockchain.BlockChain) {
+	defer conn.Close()
+
+	//a node that sends too much data or never ends its message can't keep the connection
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		fmt.Printf("Can't read the message from %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	req, err := ioutil.ReadAll(io.LimitReader(conn, maxMessageSize+1))
+	if err != nil {
+		fmt.Printf("Can't read the message from %s: %s\n", conn.RemoteAddr(), err)
+		return
+	}
+	if len(req) > maxMessageSize {
+		fmt.Printf("Message from %s is bigger than %d bytes\n", conn.RemoteAddr(), maxMessageSize)
+		return
+	}
+	if len(req) < commandLength {
+		return
+	}
+
+	command := BytesToCmd(req[:commandLength])
+	fmt.Printf("Received %s command\n", command)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch command {
+	case "addr":
+		err = HandleAddr(req)
+	case "block":
+		err = HandleBlock(req, chain)
+	case "inv":
+		err = HandleInv(req, chain)
+	case "getblocks":
+		err = HandleGetBlocks(req, chain)
+	case "getdata":
+		err = HandleGetData(req, chain)
+	case "tx":
+		err = HandleTx(req, chain)
+	case "version":
+		err = HandleVersion(req, chain)
+	default:
+		fmt.Println("Unknown command")
+	}
+	if err != nil {
+		fmt.Printf("Command %s from %s failed: %s\n", command, conn.RemoteAddr(), err)
+	}
+}
+
+// StartServer start the node nodeID with the chain of opts, if minerAddress
+// is not empty the node is a miner
+func StartServer(nodeID, minerAddress string, opts blockchain.Options) error {
+	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+	mineAddress = minerAddress
+
+	chain, err := blockchain.ContinueBlockChain(opts)
+	if err != nil {
+		return err
+	}
+	defer chain.Database.Close()
+
+	ln, err := net.Listen(protocol, nodeAddress)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	go CloseDB(chain)
+
+	mempool = blockchain.NewMempool(&blockchain.UTXOSet{Blockchain: chain})
+	chain.Mempool = mempool
+
+	if nodeAddress != CentralNode {
+		logSendError(SendVersion(CentralNode, chain))
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go HandleConnection(conn, chain)
+	}
+}
+
+// GobEncode encode data into []byte
+func GobEncode(data interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// NodeIsKnown check if addr is inside the known nodes
+func NodeIsKnown(addr string) bool {
+	for _, node := range KnownNodes {
+		if node == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CloseDB close the db when the node is stopped with ctrl+c, so the db is not corrupted
+func CloseDB(chain *blockchain.BlockChain) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+
+	//wait the message that is using the db
+	mu.Lock()
+	chain.Database.Close()
+	os.Exit(0)
+}
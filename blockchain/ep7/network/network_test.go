@@ -0,0 +1,238 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RachidP/BlockChain/blockchain"
+	"github.com/RachidP/BlockChain/wallet"
+)
+
+// TestMain run a node instead of the tests when the test binary is started by
+// TestThreeNodes, every node needs its own process because the state of the
+// node is inside the variables of the package.
+func TestMain(m *testing.M) {
+	if nodeID := os.Getenv("TEST_NODE_ID"); nodeID != "" {
+		CentralNode = os.Getenv("TEST_CENTRAL_NODE")
+		KnownNodes = []string{CentralNode}
+
+		opts := blockchain.DefaultOptions(nodeID)
+		opts.DataDir = os.Getenv("TEST_DATADIR")
+		if err := StartServer(nodeID, os.Getenv("TEST_MINER"), opts); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// nodeOutput is the output of a node, it is written by the process and read by the test.
+type nodeOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *nodeOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+func (o *nodeOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// testNode is a node started by TestThreeNodes.
+type testNode struct {
+	id     string
+	cmd    *exec.Cmd
+	output *nodeOutput
+}
+
+// freePort return a port of localhost that is not used.
+func freePort(t *testing.T) string {
+	ln, err := net.Listen(protocol, "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// copyDir copy the files of the db src into dst.
+func copyDir(t *testing.T, src, dst string) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, entry.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// startNode start the test binary as the node id, the node is stopped at the
+// end of the test if it is still running.
+func startNode(t *testing.T, id, central, dataDir, miner string) *testNode {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(),
+		"TEST_NODE_ID="+id,
+		"TEST_CENTRAL_NODE="+central,
+		"TEST_DATADIR="+dataDir,
+		"TEST_MINER="+miner,
+	)
+	node := &testNode{id: id, cmd: cmd, output: &nodeOutput{}}
+	cmd.Stdout = node.output
+	cmd.Stderr = node.output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if cmd.ProcessState == nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	})
+
+	//wait until the node accepts the connections
+	waitFor(t, node, func() bool {
+		conn, err := net.Dial(protocol, "localhost:"+id)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+
+	return node
+}
+
+// stop close the db of the node and wait the end of the process.
+func (n *testNode) stop(t *testing.T) {
+	if err := n.cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.cmd.Wait(); err != nil {
+		t.Fatalf("node %s: %s\n%s", n.id, err, n.output)
+	}
+}
+
+// waitFor wait until done return true, the test fails after 30 seconds.
+func waitFor(t *testing.T, node *testNode, done func() bool) {
+	for deadline := time.Now().Add(30 * time.Second); !done(); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for node %s, output:\n%s", node.id, node.output)
+		}
+	}
+}
+
+func TestThreeNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the nodes run in other processes")
+	}
+
+	ws := &wallet.Wallets{Wallets: make(map[string]*wallet.Wallet), Scripts: make(map[string][]byte)}
+	from, _ := ws.AddWallet()
+	to, _ := ws.AddWallet()
+	miner, _ := ws.AddWallet()
+
+	//all the nodes start from the same genesis block
+	dataDir := t.TempDir()
+	ids := []string{freePort(t), freePort(t), freePort(t)}
+	opts := blockchain.DefaultOptions(ids[0])
+	opts.DataDir = dataDir
+	chain, err := blockchain.InitBlockChain(from, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	if err := UTXOSet.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+	w, err := ws.GetWallet(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := blockchain.NewTransaction(&w, to, 10, 1, blockchain.TxLock{}, &UTXOSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		copyDir(t, opts.DBPath(), filepath.Join(dataDir, "blocks_"+id))
+	}
+
+	//the central node, the miner and a node that only follows the chain
+	central := "localhost:" + ids[0]
+	nodes := []*testNode{startNode(t, ids[0], central, dataDir, "")}
+	nodes = append(nodes, startNode(t, ids[1], central, dataDir, miner))
+	waitFor(t, nodes[0], func() bool { return strings.Count(nodes[0].output.String(), "Received version command") == 1 })
+	nodes = append(nodes, startNode(t, ids[2], central, dataDir, ""))
+	waitFor(t, nodes[0], func() bool { return strings.Count(nodes[0].output.String(), "Received version command") == 2 })
+
+	//the transaction is relayed by the central node to the other nodes, the
+	//miner mines it and the block reaches all the nodes
+	CentralNode = central
+	if err := SendTx(central, tx); err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		node := node
+		waitFor(t, node, func() bool {
+			return strings.Contains(node.output.String(), fmt.Sprintf("localhost:%s, 1\n", node.id))
+		})
+	}
+	for _, node := range nodes {
+		node := node
+		waitFor(t, node, func() bool {
+			out := node.output.String()
+			return strings.Contains(out, "New Block mined") || strings.Contains(out, "Added block")
+		})
+	}
+
+	for _, node := range nodes {
+		node.stop(t)
+
+		opts := blockchain.DefaultOptions(node.id)
+		opts.DataDir = dataDir
+		chain, err := blockchain.ContinueBlockChain(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		height, err := chain.GetBestHeight()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if height != 1 {
+			t.Errorf("node %s has height %d, want 1", node.id, height)
+		}
+		if _, err := chain.FindTransaction(tx.ID); err != nil {
+			t.Errorf("node %s: %s", node.id, err)
+		}
+		chain.Database.Close()
+	}
+}
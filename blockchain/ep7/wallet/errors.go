@@ -0,0 +1,10 @@
+package wallet
+
+import "errors"
+
+// Errors returned by the wallet package, the callers can check them with errors.Is.
+var (
+	ErrUnknownWallet   = errors.New("Wallet not found")
+	ErrInvalidAddress  = errors.New("Address is not valid")
+	ErrInvalidMnemonic = errors.New("Mnemonic is not valid")
+)
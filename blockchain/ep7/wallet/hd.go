@@ -0,0 +1,136 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// The HD wallets derive all their keys from one seed, like BIP32 on the curve
+// P256 (SLIP-0010): every key has a chain code and the child i of a key is
+// made from the HMAC-SHA512 of the key, the chain code and i. The children
+// from HardenedKeyStart are hardened, they are made from the private key
+// instead of the public key.
+const HardenedKeyStart = 0x80000000
+
+// DefaultHDPath is the path of the parent of the keys of the wallets, like
+// BIP44 the key i is DefaultHDPath/i.
+const DefaultHDPath = "m/44'/0'/0'/0"
+
+// masterKeyName is the key of the HMAC of the master key of the curve P256.
+var masterKeyName = []byte("Nist256p1 seed")
+
+// ExtendedKey is a private key with its chain code.
+type ExtendedKey struct {
+	Key       []byte //private key on 32 bytes
+	ChainCode []byte
+}
+
+// NewMasterKey return the key at the root of the tree of the seed.
+func NewMasterKey(seed []byte) *ExtendedKey {
+	data := seed
+	for {
+		sum := hmacSHA512(masterKeyName, data)
+		if key := new(big.Int).SetBytes(sum[:32]); validScalar(key) {
+			return &ExtendedKey{Key: sum[:32], ChainCode: sum[32:]}
+		}
+		//the key is not valid, try again with the hash
+		data = sum
+	}
+}
+
+// Child return the child of the key at index, hardened if index is at least HardenedKeyStart.
+func (k *ExtendedKey) Child(index uint32) *ExtendedKey {
+	curve := elliptic.P256()
+	parent := new(big.Int).SetBytes(k.Key)
+
+	var data []byte
+	if index >= HardenedKeyStart {
+		data = append([]byte{0}, k.Key...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.Key)
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	for {
+		sum := hmacSHA512(k.ChainCode, data)
+		child := new(big.Int).SetBytes(sum[:32])
+		if child.Cmp(curve.Params().N) < 0 {
+			child.Add(child, parent).Mod(child, curve.Params().N)
+			if child.Sign() != 0 {
+				return &ExtendedKey{Key: paddedBytes(child), ChainCode: sum[32:]}
+			}
+		}
+		//the child is not valid, try again with the right half of the hash
+		data = binary.BigEndian.AppendUint32(append([]byte{1}, sum[32:]...), index)
+	}
+}
+
+// Derive return the key at the end of the path from k, see ParsePath.
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, index := range indexes {
+		k = k.Child(index)
+	}
+	return k, nil
+}
+
+// Wallet return the wallet of the key.
+func (k *ExtendedKey) Wallet() *Wallet {
+	curve := elliptic.P256()
+	privKey := ecdsa.PrivateKey{D: new(big.Int).SetBytes(k.Key)}
+	privKey.PublicKey.Curve = curve
+	privKey.PublicKey.X, privKey.PublicKey.Y = curve.ScalarBaseMult(k.Key)
+
+	publicKey := append(paddedBytes(privKey.PublicKey.X), paddedBytes(privKey.PublicKey.Y)...)
+	return &Wallet{PrivateKey: privKey, PublicKey: publicKey}
+}
+
+// ParsePath return the indexes of a path like "m/44'/0'/0'/0", the indexes
+// with ' (or h) are hardened.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("Path %q doesn't start with m", path)
+	}
+
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || index >= HardenedKeyStart {
+			return nil, fmt.Errorf("Path %q is not valid", path)
+		}
+		if hardened {
+			index += HardenedKeyStart
+		}
+		indexes = append(indexes, uint32(index))
+	}
+
+	return indexes, nil
+}
+
+// validScalar check that n is a valid private key of P256.
+func validScalar(n *big.Int) bool {
+	return n.Sign() > 0 && n.Cmp(elliptic.P256().Params().N) < 0
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// A mnemonic is the backup of the seed of the HD wallets, written with the
+// words of BIP39: the entropy is followed by the first bits of its sha256 as
+// checksum, and every word encodes 11 bits. 128 bits of entropy are 12 words,
+// 256 bits are 24 words.
+
+// NewMnemonic return a mnemonic with bits of random entropy, bits must be a
+// multiple of 32 from 128 to 256.
+func NewMnemonic(bits int) (string, error) {
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", fmt.Errorf("Entropy of %d bits is not valid, it must be 128, 160, 192, 224 or 256", bits)
+	}
+
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return entropyToMnemonic(entropy), nil
+}
+
+// entropyToMnemonic return the words of the entropy followed by its checksum.
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := len(entropy) * 8 / 32
+	checksum := sha256.Sum256(entropy)
+
+	//the entropy and the checksum as one number
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, big.NewInt(int64(checksum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// mnemonicToEntropy return the entropy of the mnemonic, it fails with
+// ErrInvalidMnemonic if a word is not known or the checksum is wrong.
+func mnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+
+	n := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex(word)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not a word of the list", ErrInvalidMnemonic, word)
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	checksum := new(big.Int).And(n, big.NewInt(int64(1)<<uint(checksumBits)-1))
+	n.Rsh(n, uint(checksumBits))
+	entropy := n.FillBytes(make([]byte, checksumBits*4))
+
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-checksumBits)) {
+		return nil, fmt.Errorf("%w: the checksum is wrong", ErrInvalidMnemonic)
+	}
+
+	return entropy, nil
+}
+
+// wordIndex return the index of the word inside the list.
+func wordIndex(word string) (int, bool) {
+	low, high := 0, len(wordList)
+	for low < high {
+		mid := (low + high) / 2
+		if wordList[mid] < word {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return low, low < len(wordList) && wordList[low] == word
+}
+
+// ValidateMnemonic check the words and the checksum of the mnemonic.
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := mnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicSeed return the 64 bytes seed of the mnemonic protected by the
+// passphrase (it can be empty), like BIP39. It fails with ErrInvalidMnemonic
+// if the mnemonic is not valid.
+func MnemonicSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := mnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	//the list has only ascii words, so they are already normalized
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
@@ -1,8 +1,6 @@
 package wallet
 
 import (
-	"log"
-
 	"github.com/mr-tron/base58"
 )
 
@@ -11,11 +9,6 @@ func Base58Encode(input []byte) []byte {
 	return []byte(encode)
 }
 
-func Base58Decode(input []byte) []byte {
-	decode, err := base58.Decode(string(input[:]))
-	if err != nil {
-		log.Panic(err)
-	}
-	return decode
-
+func Base58Decode(input []byte) ([]byte, error) {
+	return base58.Decode(string(input[:]))
 }
@@ -6,7 +6,9 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"log"
+	"encoding/gob"
+	"fmt"
+	"math/big"
 
 	"golang.org/x/crypto/ripemd160"
 )
@@ -14,6 +16,8 @@ import (
 const (
 	checksumLength = 4
 	version        = byte(0x00)
+	scriptVersion  = byte(0x05) //version of the addresses of the scripts, e.g. the multisig addresses
+	numberLength   = 32         //bytes of the numbers of the P256 keys and signatures
 )
 
 type Wallet struct {
@@ -21,25 +25,128 @@ type Wallet struct {
 	PublicKey  []byte
 }
 
+//walletData is the data of the wallet that we save on the disk, the curve is
+//always P256 so we save only the numbers of the keys.
+type walletData struct {
+	D, X, Y   []byte
+	PublicKey []byte
+}
+
+//GobEncode encode the wallet into []byte
+func (w Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+	data := walletData{
+		D:         w.PrivateKey.D.Bytes(),
+		X:         w.PrivateKey.PublicKey.X.Bytes(),
+		Y:         w.PrivateKey.PublicKey.Y.Bytes(),
+		PublicKey: w.PublicKey,
+	}
+	err := gob.NewEncoder(&content).Encode(data)
+	return content.Bytes(), err
+}
+
+//GobDecode decode the []byte into a wallet
+func (w *Wallet) GobDecode(content []byte) error {
+	var data walletData
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&data)
+	if err != nil {
+		return err
+	}
+	w.PrivateKey.PublicKey.Curve = elliptic.P256()
+	w.PrivateKey.PublicKey.X = new(big.Int).SetBytes(data.X)
+	w.PrivateKey.PublicKey.Y = new(big.Int).SetBytes(data.Y)
+	w.PrivateKey.D = new(big.Int).SetBytes(data.D)
+	w.PublicKey = data.PublicKey
+	return nil
+}
+
 //NewKeyPair create a Private and Public key
-func NewKeyPair() (ecdsa.PrivateKey, []byte) {
+func NewKeyPair() (ecdsa.PrivateKey, []byte, error) {
 
 	//define the type of the elliptic curve (in this cas is a 256)
 	curve := elliptic.P256()
 	privateKey, err := ecdsa.GenerateKey(curve, rand.Reader) //generate the privateKey
 	if err != nil {
-		log.Panic(err)
+		return ecdsa.PrivateKey{}, nil, err
 	}
 	//generate public key
-	publicKey := append(privateKey.PublicKey.X.Bytes(), privateKey.PublicKey.Y.Bytes()...)
-	return *privateKey, publicKey
+	publicKey := append(paddedBytes(privateKey.PublicKey.X), paddedBytes(privateKey.PublicKey.Y)...)
+	return *privateKey, publicKey, nil
+}
+
+//paddedBytes return the number on numberLength bytes, so the two numbers of a
+//key or of a signature can be split in the middle.
+func paddedBytes(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, numberLength))
+}
+
+// Sign sign hash with privKey, the signature is r and s on 32 bytes each.
+func Sign(privKey *ecdsa.PrivateKey, hash []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(paddedBytes(r), paddedBytes(s)...), nil
+}
+
+// Verify check that signature is the signature of hash made by the key of
+// pubKey. The old versions didn't pad the numbers of the public keys and of
+// the signatures, so a number can be shorter than 32 bytes: every position
+// where the two numbers can be split is tried.
+func Verify(pubKey, hash, signature []byte) bool {
+	key := parsePublicKey(pubKey)
+	if key == nil {
+		return false
+	}
+
+	for _, rs := range splitNumbers(signature) {
+		if ecdsa.Verify(key, hash, rs[0], rs[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidPublicKey check that pubKey is a point of the curve P256.
+func ValidPublicKey(pubKey []byte) bool {
+	return parsePublicKey(pubKey) != nil
+}
+
+//parsePublicKey return the key of pubKey, nil if it is not a point of the curve.
+func parsePublicKey(pubKey []byte) *ecdsa.PublicKey {
+	curve := elliptic.P256()
+	for _, xy := range splitNumbers(pubKey) {
+		if curve.IsOnCurve(xy[0], xy[1]) {
+			return &ecdsa.PublicKey{Curve: curve, X: xy[0], Y: xy[1]}
+		}
+	}
+
+	return nil
+}
+
+//splitNumbers return the pairs of numbers of at most 32 bytes that can be written in data.
+func splitNumbers(data []byte) [][2]*big.Int {
+	var pairs [][2]*big.Int
+	for i := len(data) - numberLength; i <= numberLength; i++ {
+		if i < 1 || i >= len(data) {
+			continue
+		}
+		pairs = append(pairs, [2]*big.Int{new(big.Int).SetBytes(data[:i]), new(big.Int).SetBytes(data[i:])})
+	}
+
+	return pairs
 }
 
 //MakeWallet make the Wallet with the private a bublicKey.
-func MakeWallet() *Wallet {
-	privKey, pubKey := NewKeyPair()
+func MakeWallet() (*Wallet, error) {
+	privKey, pubKey, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
 	wallet := Wallet{PrivateKey: privKey, PublicKey: pubKey}
-	return &wallet
+	return &wallet, nil
 
 }
 
@@ -48,10 +155,7 @@ func PublicKeyHash(pubKey []byte) []byte {
 	pubHash := sha256.Sum256(pubKey)
 
 	hasher := ripemd160.New()
-	_, err := hasher.Write(pubHash[:])
-	if err != nil {
-		log.Panic(err)
-	}
+	hasher.Write(pubHash[:]) //the Write of a hash never returns an error
 	publicRipMD := hasher.Sum(nil)
 	return publicRipMD
 }
@@ -66,20 +170,50 @@ func CheckSum(pubKeyHash []byte) []byte {
 
 //Address generate an address for each wallet
 func (w Wallet) Address() []byte {
-	pubHash := PublicKeyHash(w.PublicKey)
-	versionHash := append([]byte{version}, pubHash...)
+	return PubKeyHashAddress(PublicKeyHash(w.PublicKey))
+}
+
+// PubKeyHashAddress return the address of the wallet with the public key hash.
+func PubKeyHashAddress(pubKeyHash []byte) []byte {
+	return encodeAddress(version, pubKeyHash)
+}
+
+// ScriptAddress return the address of the outputs that are locked to the hash
+// of script (P2SH), the address has its own version so it is not mixed up
+// with the address of a wallet.
+func ScriptAddress(script []byte) []byte {
+	return ScriptHashAddress(PublicKeyHash(script))
+}
+
+// ScriptHashAddress return the address of the script with the hash scriptHash.
+func ScriptHashAddress(scriptHash []byte) []byte {
+	return encodeAddress(scriptVersion, scriptHash)
+}
+
+//encodeAddress return the base58 of the version, the hash and the checksum.
+func encodeAddress(version byte, hash []byte) []byte {
+	versionHash := append([]byte{version}, hash...)
 	checkSum := CheckSum(versionHash)
 	fullHash := append(versionHash, checkSum...)
-	address := Base58Encode(fullHash)
 
-	// fmt.Printf("pub key : %x\n", w.PublicKey)
-	// fmt.Printf("pub hash: %x\n", pubHash)
-	// fmt.Printf("address : %x\n", address)
+	return Base58Encode(fullHash)
+}
 
-	return address
+// IsScriptAddress return true if the address is a valid address of a script.
+func IsScriptAddress(address string) bool {
+	if !ValidateAddress(address) {
+		return false
+	}
+	fullHash, err := Base58Decode([]byte(address))
+
+	return err == nil && fullHash[0] == scriptVersion
 }
+
 func ValidateAddress(address string) bool {
-	pubKeyHash := Base58Decode([]byte(address))
+	pubKeyHash, err := Base58Decode([]byte(address))
+	if err != nil || len(pubKeyHash) <= checksumLength {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
 	version := pubKeyHash[0]
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
@@ -87,6 +221,20 @@ func ValidateAddress(address string) bool {
 
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
+
+// AddressToPubKeyHash return the public key hash inside the address, it fails
+// with ErrInvalidAddress if the address is not valid.
+func AddressToPubKeyHash(address string) ([]byte, error) {
+	if !ValidateAddress(address) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, address)
+	}
+	fullHash, err := Base58Decode([]byte(address))
+	if err != nil {
+		return nil, err
+	}
+
+	return fullHash[1 : len(fullHash)-checksumLength], nil
+}
 func Checksum(payload []byte) []byte {
 	firstHash := sha256.Sum256(payload)
 	secondHash := sha256.Sum256(firstHash[:])
@@ -2,39 +2,57 @@ package wallet
 
 import (
 	"bytes"
-	"crypto/elliptic"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 )
 
-const walletFile = "./tmp/wallets.data" //define where to store the wallet on the disk
-
 //Wallets
 type Wallets struct {
 	Wallets map[string]*Wallet //we store the address as a key and PublicKey and PrivateKey as a value
+	Scripts map[string][]byte  //the scripts of the multisig addresses, the key is the address of the script
+	HD      *HDSeed            //seed of the HD wallets, nil if the keys are random
+}
+
+// HDSeed is the seed of the HD wallets, the key i is Path/i and the next key
+// made by AddWallet is Next. The keys can be made again from the mnemonic of
+// the seed.
+type HDSeed struct {
+	Seed []byte
+	Path string
+	Next uint32
+}
+
+// Key return the wallet of the key at index.
+func (hd *HDSeed) Key(index uint32) (*Wallet, error) {
+	parent, err := NewMasterKey(hd.Seed).Derive(hd.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parent.Child(index).Wallet(), nil
 }
 
-//save the wallet into file
-func (ws *Wallets) SaveFile() {
+//save the wallet into the file walletFile
+func (ws *Wallets) SaveFile(walletFile string) error {
 	var content bytes.Buffer
-	gob.Register(elliptic.P256())
+	if err := os.MkdirAll(filepath.Dir(walletFile), 0755); err != nil {
+		return err
+	}
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	if err != nil {
-		log.Panic(err)
-	}
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
-	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
+	return ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 }
 
-//LoadFile load the wallet from the db/file
-func (ws *Wallets) LoadFile() error {
+//LoadFile load the wallet from the file walletFile
+func (ws *Wallets) LoadFile(walletFile string) error {
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -44,7 +62,6 @@ func (ws *Wallets) LoadFile() error {
 	if err != nil {
 		return err
 	}
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 	if err != nil {
@@ -52,23 +69,35 @@ func (ws *Wallets) LoadFile() error {
 	}
 
 	ws.Wallets = wallets.Wallets
+	//the old files don't have the scripts
+	if wallets.Scripts != nil {
+		ws.Scripts = wallets.Scripts
+	}
+	ws.HD = wallets.HD
 	return nil
 }
 
-// CreateWallets popolate the wallet.
-func CreateWallets() (*Wallets, error) {
+// CreateWallets popolate the wallet from the file walletFile, the file of a
+// chain is given by blockchain.Options.WalletFile.
+func CreateWallets(walletFile string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.Scripts = make(map[string][]byte)
 
-	err := wallets.LoadFile()
+	err := wallets.LoadFile(walletFile)
 
 	return &wallets, err
 }
 
-// GetWallet get the wallet for a specific address.
-func (ws *Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+// GetWallet get the wallet for a specific address, it fails with
+// ErrUnknownWallet if the address is not inside the wallet file.
+func (ws *Wallets) GetWallet(address string) (Wallet, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, fmt.Errorf("%w: %s", ErrUnknownWallet, address)
+	}
 
+	return *wallet, nil
 }
 
 // GetAllAddresses return all the addresses in the wallet structure.
@@ -82,10 +111,103 @@ func (ws *Wallets) GetAllAddresses() []string {
 
 }
 
-//AddWallet add a wallet into wallets memory map.
-func (ws *Wallets) AddWallet() string {
-	wallet := MakeWallet()
+//AddWallet add a wallet into wallets memory map, it is the next key of the
+//seed if the wallets have one, otherwise a random key.
+func (ws *Wallets) AddWallet() (string, error) {
+	if ws.HD != nil {
+		address, err := ws.AddHDWallet(ws.HD.Next)
+		if err != nil {
+			return "", err
+		}
+		ws.HD.Next++
+		return address, nil
+	}
+
+	wallet, err := MakeWallet()
+	if err != nil {
+		return "", err
+	}
 	address := fmt.Sprintf("%s", wallet.Address())
 	ws.Wallets[address] = wallet
+	return address, nil
+}
+
+// SetSeed make the wallets HD: the next keys of AddWallet are derived from
+// the seed along path. It fails if the wallets have already a seed.
+func (ws *Wallets) SetSeed(seed []byte, path string) error {
+	if ws.HD != nil {
+		return errors.New("Wallets have already a seed")
+	}
+	if _, err := ParsePath(path); err != nil {
+		return err
+	}
+
+	ws.HD = &HDSeed{Seed: seed, Path: path}
+	return nil
+}
+
+// AddHDWallet add the key at index of the seed and return its address.
+func (ws *Wallets) AddHDWallet(index uint32) (string, error) {
+	if ws.HD == nil {
+		return "", errors.New("Wallets don't have a seed")
+	}
+
+	wallet, err := ws.HD.Key(index)
+	if err != nil {
+		return "", err
+	}
+	address := string(wallet.Address())
+	ws.Wallets[address] = wallet
+	return address, nil
+}
+
+// AddScript add a script that the wallets can sign (e.g. a multisig script)
+// and return its address.
+func (ws *Wallets) AddScript(script []byte) string {
+	address := string(ScriptAddress(script))
+	ws.Scripts[address] = script
 	return address
 }
+
+// GetScript return the script of the address, it fails with ErrUnknownWallet
+// if the script is not inside the wallet file.
+func (ws *Wallets) GetScript(address string) ([]byte, error) {
+	script, ok := ws.Scripts[address]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWallet, address)
+	}
+
+	return script, nil
+}
+
+// GetScriptAddresses return the addresses of the scripts in the wallet structure.
+func (ws *Wallets) GetScriptAddresses() []string {
+	var addresses []string
+	for address := range ws.Scripts {
+		addresses = append(addresses, address)
+	}
+
+	return addresses
+}
+
+// WalletOf return the wallet of the key with the hash pubKeyHash.
+func (ws *Wallets) WalletOf(pubKeyHash []byte) (*Wallet, bool) {
+	for _, w := range ws.Wallets {
+		if bytes.Equal(PublicKeyHash(w.PublicKey), pubKeyHash) {
+			return w, true
+		}
+	}
+
+	return nil, false
+}
+
+// ScriptOf return the script with the hash scriptHash.
+func (ws *Wallets) ScriptOf(scriptHash []byte) ([]byte, bool) {
+	for _, script := range ws.Scripts {
+		if bytes.Equal(PublicKeyHash(script), scriptHash) {
+			return script, true
+		}
+	}
+
+	return nil, false
+}